@@ -2,6 +2,7 @@ package getcd
 
 import (
 	"context"
+	"crypto/tls"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/DataWorkbench/glog"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/tlsutil"
 )
 
 // Config is a copy of clientv3.Config.
@@ -21,6 +23,31 @@ type Config struct {
 	// eg: "127.0.0.1:2379" or "127.0.0.1:2379,127.0.0.2:2379,127.0.0.3:2379".
 	Endpoints   string        `json:"endpoints" yaml:"endpoints" env:"ENDPOINTS" validate:"required"`
 	DialTimeout time.Duration `json:"dial_timeout" yaml:"dial_timeout" env:"DIAL_TIMEOUT,default=5s" validate:"required"`
+
+	// DialKeepAliveTime is the time after which client pings the server to see if transport is alive.
+	// 0 indicates disabled.
+	DialKeepAliveTime time.Duration `json:"dial_keep_alive_time" yaml:"dial_keep_alive_time" env:"DIAL_KEEP_ALIVE_TIME,default=10s" validate:"gte=0"`
+	// DialKeepAliveTimeout is the time that the client waits for a response for the keep-alive probe.
+	DialKeepAliveTimeout time.Duration `json:"dial_keep_alive_timeout" yaml:"dial_keep_alive_timeout" env:"DIAL_KEEP_ALIVE_TIMEOUT,default=3s" validate:"gte=0"`
+	// AutoSyncInterval is the interval to update endpoints with its latest members.
+	// 0 indicates disabled.
+	AutoSyncInterval time.Duration `json:"auto_sync_interval" yaml:"auto_sync_interval" env:"AUTO_SYNC_INTERVAL,default=0s" validate:"gte=0"`
+
+	// Username and Password for etcd authentication. Leave empty if auth is not enabled.
+	Username string `json:"username" yaml:"username" env:"USERNAME"`
+	Password string `json:"password" yaml:"password" env:"PASSWORD"`
+
+	// TLSEnabled controls whether connects to server with TLS.
+	TLSEnabled bool `json:"tls_enabled" yaml:"tls_enabled" env:"TLS_ENABLED,default=false"`
+	// The PEM encoded CA bundle to verify the server. Uses system roots if empty.
+	TLSCAFile string `json:"tls_ca_file" yaml:"tls_ca_file" env:"TLS_CA_FILE"`
+	// The client key pair for mTLS. Both are optional.
+	TLSCertFile string `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE" validate:"required_with=TLSKeyFile"`
+	TLSKeyFile  string `json:"tls_key_file"  yaml:"tls_key_file"  env:"TLS_KEY_FILE"  validate:"required_with=TLSCertFile"`
+	// TLSServerName overrides the server name used to verify the server certificate.
+	TLSServerName string `json:"tls_server_name" yaml:"tls_server_name" env:"TLS_SERVER_NAME"`
+	// TLSInsecureSkipVerify disables verification of server certificate. Only used for testing.
+	TLSInsecureSkipVerify bool `json:"tls_insecure_skip_verify" yaml:"tls_insecure_skip_verify" env:"TLS_INSECURE_SKIP_VERIFY,default=false"`
 }
 
 // NewClient creates a new etcd Client.
@@ -38,11 +65,32 @@ func NewClient(ctx context.Context, cfg *Config) (cli *Client, err error) {
 		grpc_prometheus.StreamClientInterceptor,
 	))
 
-	lg.Debug().String("etcd: connecting to server endpoints", cfg.Endpoints).Fire()
+	var tlsCfg *tls.Config
+	if cfg.TLSEnabled {
+		tlsCfg, err = tlsutil.NewClientConfig(&tlsutil.Options{
+			CAFile:             cfg.TLSCAFile,
+			CertFile:           cfg.TLSCertFile,
+			KeyFile:            cfg.TLSKeyFile,
+			ServerName:         cfg.TLSServerName,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		})
+		if err != nil {
+			lg.Error().Error("etcd: load tls config error", err).Fire()
+			return
+		}
+	}
+
+	lg.Debug().String("etcd: connecting to server endpoints", cfg.Endpoints).Bool("tls", cfg.TLSEnabled).Fire()
 	cli, err = etcdv3.New(etcdv3.Config{
-		Endpoints:   strings.Split(cfg.Endpoints, ","),
-		DialTimeout: cfg.DialTimeout,
-		DialOptions: dialOpts,
+		Endpoints:            strings.Split(cfg.Endpoints, ","),
+		AutoSyncInterval:     cfg.AutoSyncInterval,
+		DialTimeout:          cfg.DialTimeout,
+		DialKeepAliveTime:    cfg.DialKeepAliveTime,
+		DialKeepAliveTimeout: cfg.DialKeepAliveTimeout,
+		TLS:                  tlsCfg,
+		Username:             cfg.Username,
+		Password:             cfg.Password,
+		DialOptions:          dialOpts,
 	})
 
 	if err != nil {
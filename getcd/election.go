@@ -8,8 +8,29 @@ import (
 	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
+// ElectionOption used to change the behavior of RetryElection.
+type ElectionOption func(o *electionOptions)
+
+type electionOptions struct {
+	sessionTTL int
+}
+
+// WithSessionTTL sets the TTL in seconds of the session that used in election. Defaults 60.
+//
+// The leader will notice the session lost in about ttl/3 seconds after it expired.
+func WithSessionTTL(ttl int) ElectionOption {
+	return func(o *electionOptions) {
+		o.sessionTTL = ttl
+	}
+}
+
 // RetryElection master worker election, the notifyCallback will be invoking if the election was success.
-func RetryElection(ctx context.Context, cli *Client, key string, value string, offer func(ctxCancel context.Context)) {
+func RetryElection(ctx context.Context, cli *Client, key string, value string, offer func(ctxCancel context.Context), opts ...ElectionOption) {
+	eo := &electionOptions{sessionTTL: 60}
+	for _, opt := range opts {
+		opt(eo)
+	}
+
 	// new logger.
 	nl := glog.FromContext(ctx).Clone()
 	nl.ResetFields().AddString("key", key)
@@ -24,7 +45,7 @@ LOOP:
 		sleep = true
 
 		nl.Info().Msg("etcd: start leader election").Fire()
-		sess, err := concurrency.NewSession(cli, concurrency.WithTTL(60))
+		sess, err := concurrency.NewSession(cli, concurrency.WithTTL(eo.sessionTTL))
 		if err != nil {
 			nl.Error().Msg("etcd: concurrency new session failed and retry now").Error("error", err).Fire()
 			continue LOOP
@@ -0,0 +1,60 @@
+package etcdtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/getcd"
+	"github.com/DataWorkbench/common/getcd/etcdtest"
+)
+
+func TestRetryElection(t *testing.T) {
+	srv := etcdtest.New(t)
+
+	ctx, cancel := context.WithCancel(etcdtest.Context())
+	defer cancel()
+
+	terms := make(chan context.Context, 8)
+	exited := make(chan struct{})
+	go func() {
+		getcd.RetryElection(ctx, srv.Client(), "/etcdtest/election", "worker-1", func(ctxCancel context.Context) {
+			terms <- ctxCancel
+			<-ctxCancel.Done()
+		}, getcd.WithSessionTTL(3))
+		close(exited)
+	}()
+
+	var first context.Context
+	select {
+	case first = <-terms:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the first term")
+	}
+
+	// Lose the leadership by session expired and elect again.
+	srv.ExpireSessions()
+
+	select {
+	case <-first.Done():
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the first term end")
+	}
+
+	var second context.Context
+	select {
+	case second = <-terms:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the second term")
+	}
+
+	cancel()
+	select {
+	case <-exited:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the election exit")
+	}
+	require.NotNil(t, second.Err())
+}
@@ -0,0 +1,175 @@
+// Package etcdtest provides an embedded etcd server for testing code that depends on getcd.
+// It is a separate module so that the etcd server is not a dependency of the common module,
+// and it also holds the tests of getcd that need a running etcd.
+//
+// Usage:
+//
+//	func TestXXX(t *testing.T) {
+//		srv := etcdtest.New(t)
+//		cli := srv.Client()
+//		...
+//		// Force all sessions (elections, mutexes) to expire.
+//		srv.ExpireSessions()
+//		// Compact the history to the current revision.
+//		srv.Compact()
+//	}
+package etcdtest
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+
+	"github.com/DataWorkbench/common/getcd"
+)
+
+// startTimeout is the max duration to wait the embedded server ready.
+const startTimeout = time.Second * 30
+
+// Server is an embedded single node etcd server that listens on a random local port.
+type Server struct {
+	t        testing.TB
+	etcd     *embed.Etcd
+	endpoint string
+	client   *getcd.Client
+}
+
+// New starts an embedded etcd server and returns it after the server is ready.
+// The server and the clients created by it will be closed automatically when the test finished.
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	clientURL := url.URL{Scheme: "http", Host: freeAddress(t)}
+	peerURL := url.URL{Scheme: "http", Host: freeAddress(t)}
+
+	cfg := embed.NewConfig()
+	cfg.Name = "etcdtest"
+	cfg.Dir = filepath.Join(t.TempDir(), "etcd")
+	cfg.ZapLoggerBuilder = embed.NewZapLoggerBuilder(zap.NewNop())
+	cfg.LCUrls = []url.URL{clientURL}
+	cfg.ACUrls = []url.URL{clientURL}
+	cfg.LPUrls = []url.URL{peerURL}
+	cfg.APUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("etcdtest: start embedded etcd error: %v", err)
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(startTimeout):
+		e.Server.Stop()
+		e.Close()
+		t.Fatalf("etcdtest: embedded etcd not ready in %s", startTimeout)
+	}
+
+	s := &Server{
+		t:        t,
+		etcd:     e,
+		endpoint: clientURL.Host,
+	}
+	t.Cleanup(s.close)
+
+	s.client = s.NewClient()
+	return s
+}
+
+// Endpoint returns the client endpoint of server. eg: "127.0.0.1:23790".
+func (s *Server) Endpoint() string {
+	return s.endpoint
+}
+
+// Client returns a ready client that connected to the server.
+func (s *Server) Client() *getcd.Client {
+	return s.client
+}
+
+// NewClient creates a new independent client that connected to the server,
+// It will be closed automatically when the test finished.
+func (s *Server) NewClient() *getcd.Client {
+	s.t.Helper()
+
+	cli, err := getcd.NewClient(Context(), &getcd.Config{
+		Endpoints:   s.endpoint,
+		DialTimeout: time.Second * 5,
+	})
+	if err != nil {
+		s.t.Fatalf("etcdtest: create client error: %v", err)
+	}
+	s.t.Cleanup(func() { _ = cli.Close() })
+	return cli
+}
+
+// ExpireSessions revokes all leases in the server. It makes all sessions that created by
+// concurrency.NewSession expired immediately, so the election and mutex will lose.
+func (s *Server) ExpireSessions() {
+	s.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp, err := s.client.Leases(ctx)
+	if err != nil {
+		s.t.Fatalf("etcdtest: list leases error: %v", err)
+	}
+	for _, lease := range resp.Leases {
+		if _, err = s.client.Revoke(ctx, lease.ID); err != nil {
+			s.t.Fatalf("etcdtest: revoke lease %x error: %v", lease.ID, err)
+		}
+	}
+}
+
+// Compact compacts the key-value history up to the current revision and returns the revision.
+// Watchers that started at a revision before it will be canceled with ErrCompacted.
+func (s *Server) Compact() int64 {
+	s.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// Write a dummy key to get the current revision.
+	resp, err := s.client.Put(ctx, "etcdtest/compact", "")
+	if err != nil {
+		s.t.Fatalf("etcdtest: put key error: %v", err)
+	}
+	rev := resp.Header.Revision
+	if _, err = s.client.Compact(ctx, rev, etcdv3.WithCompactPhysical()); err != nil {
+		s.t.Fatalf("etcdtest: compact to revision %d error: %v", rev, err)
+	}
+	return rev
+}
+
+func (s *Server) close() {
+	s.etcd.Server.Stop()
+	s.etcd.Close()
+}
+
+// Context returns a context.Context with a logger that only outputs errors.
+// It can be used in the functions of getcd which requires a glog.Logger in the ctx.
+func Context() context.Context {
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+	return glog.WithContext(context.Background(), lp)
+}
+
+// freeAddress returns a free local tcp address.
+func freeAddress(t testing.TB) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("etcdtest: get free address error: %v", err)
+	}
+	addr := lis.Addr().String()
+	if err = lis.Close(); err != nil {
+		t.Fatalf("etcdtest: close listener error: %v", err)
+	}
+	return addr
+}
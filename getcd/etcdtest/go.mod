@@ -0,0 +1,14 @@
+module github.com/DataWorkbench/common/getcd/etcdtest
+
+go 1.15
+
+require (
+	github.com/DataWorkbench/common v0.0.0
+	github.com/DataWorkbench/glog v0.0.0-20220302035436-25a1ae256704
+	github.com/stretchr/testify v1.7.0
+	go.etcd.io/etcd/client/v3 v3.5.5
+	go.etcd.io/etcd/server/v3 v3.5.5
+	go.uber.org/zap v1.17.0
+)
+
+replace github.com/DataWorkbench/common => ../..
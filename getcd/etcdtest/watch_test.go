@@ -0,0 +1,159 @@
+package etcdtest_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/DataWorkbench/common/getcd"
+	"github.com/DataWorkbench/common/getcd/etcdtest"
+)
+
+type watchRecorder struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (r *watchRecorder) handle(ctx context.Context, eventType getcd.EventType, kv *getcd.KeyValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch eventType {
+	case getcd.EventPUT:
+		r.keys[string(kv.Key)] = string(kv.Value)
+	case getcd.EventDELETE:
+		delete(r.keys, string(kv.Key))
+	}
+}
+
+func (r *watchRecorder) waitFor(t *testing.T, cond func(keys map[string]string) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		ok := cond(r.keys)
+		r.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("timeout waiting for watch events")
+}
+
+func TestRetryWatch(t *testing.T) {
+	srv := etcdtest.New(t)
+	cli := srv.Client()
+
+	ctx, cancel := context.WithCancel(etcdtest.Context())
+	defer cancel()
+
+	// More than one page of exists keys.
+	for i := 0; i < 150; i++ {
+		_, err := cli.Put(ctx, fmt.Sprintf("/etcdtest/watch/%03d", i), "v0")
+		require.Nil(t, err)
+	}
+	// Key out of the prefix.
+	_, err := cli.Put(ctx, "/etcdtest/other", "v0")
+	require.Nil(t, err)
+
+	r := &watchRecorder{keys: make(map[string]string)}
+	exited := make(chan struct{})
+	go func() {
+		getcd.RetryWatch(ctx, cli, "/etcdtest/watch/", r.handle)
+		close(exited)
+	}()
+
+	r.waitFor(t, func(keys map[string]string) bool { return len(keys) == 150 })
+
+	_, err = cli.Put(ctx, "/etcdtest/watch/000", "v1")
+	require.Nil(t, err)
+	_, err = cli.Delete(ctx, "/etcdtest/watch/001")
+	require.Nil(t, err)
+	r.waitFor(t, func(keys map[string]string) bool {
+		_, ok := keys["/etcdtest/watch/001"]
+		return keys["/etcdtest/watch/000"] == "v1" && !ok
+	})
+
+	cancel()
+	select {
+	case <-exited:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the watch exit")
+	}
+}
+
+func TestRetryWatchCompacted(t *testing.T) {
+	srv := etcdtest.New(t)
+	cli := srv.Client()
+
+	ctx, cancel := context.WithCancel(etcdtest.Context())
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		_, err := cli.Put(ctx, fmt.Sprintf("/etcdtest/watch/%03d", i), "v0")
+		require.Nil(t, err)
+	}
+
+	r := &watchRecorder{keys: make(map[string]string)}
+	var compacted, listed int32
+	handler := func(ctx context.Context, eventType getcd.EventType, kv *getcd.KeyValue) {
+		r.handle(ctx, eventType, kv)
+		if string(kv.Key) == "/etcdtest/watch/000" {
+			atomic.AddInt32(&listed, 1)
+		}
+		if string(kv.Key) != "/etcdtest/watch/009" || !atomic.CompareAndSwapInt32(&compacted, 0, 1) {
+			return
+		}
+		// Changes and compacts the history after the keys listed and before the watch started,
+		// so the watch starts from a compacted revision and has to list the keys again.
+		// The handler is called by the watch goroutine, so do not use require here.
+		if _, err := cli.Put(ctx, "/etcdtest/watch/100", "v1"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := cli.Delete(ctx, "/etcdtest/watch/005"); err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := cli.Put(ctx, "/etcdtest/other", "v1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err = cli.Compact(ctx, resp.Header.Revision, etcdv3.WithCompactPhysical()); err != nil {
+			t.Error(err)
+		}
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		getcd.RetryWatch(ctx, cli, "/etcdtest/watch/", handler)
+		close(exited)
+	}()
+
+	r.waitFor(t, func(keys map[string]string) bool { return keys["/etcdtest/watch/100"] == "v1" })
+	// The key 005 deleted in the compacted history is notified by the list.
+	r.waitFor(t, func(keys map[string]string) bool {
+		_, ok := keys["/etcdtest/watch/005"]
+		return !ok && len(keys) == 10
+	})
+	// The key 000 is only put once, so it's listed twice by the retry.
+	require.Equal(t, int32(2), atomic.LoadInt32(&listed))
+
+	_, err := cli.Put(ctx, "/etcdtest/watch/101", "v2")
+	require.Nil(t, err)
+	r.waitFor(t, func(keys map[string]string) bool { return keys["/etcdtest/watch/101"] == "v2" })
+
+	cancel()
+	select {
+	case <-exited:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout waiting for the watch exit")
+	}
+}
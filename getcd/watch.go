@@ -2,6 +2,7 @@ package getcd
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/DataWorkbench/glog"
@@ -9,59 +10,149 @@ import (
 	etcdv3 "go.etcd.io/etcd/client/v3"
 )
 
+// watchListLimit is the max number of keys that get in each request when listing exists keys.
+const watchListLimit = 100
+
+var errWatchClosed = errors.New("etcd: watch channel closed")
+
 // RetryWatch do watch the specified key(prefix) and auto retry when etcd error.
+//
+// The handler will be called with EventPUT for every exists keys first, and then called with
+// the events since. If the watch is canceled by server (e.g. the revision has been compacted),
+// all keys will be listed and notified again, so the handler should be idempotent. The keys
+// that deleted during the retry are notified with EventDELETE and a KeyValue that only has the
+// Key and ModRevision set.
 func RetryWatch(ctx context.Context, cli *Client, key string, handler func(ctx context.Context, eventType EventType, kv *KeyValue)) {
 	// new logger.
 	nl := glog.FromContext(ctx)
 
-	var sleep bool
+	// The keys that have been notified with EventPUT and not deleted yet.
+	known := make(map[string]struct{})
 
-	revision := int64(0)
-	more := true
-	for more {
+	var sleep bool
+	for {
 		if sleep {
 			// Sleep to prevents died loop.
 			time.Sleep(time.Millisecond * 100)
 		}
 		sleep = true
 
-		var err error
-		var resp *etcdv3.GetResponse
-		resp, err = cli.Get(ctx, key, etcdv3.WithPrefix(), etcdv3.WithLimit(100), etcdv3.WithRev(revision))
+		revision, ok := listExistsKeys(ctx, cli, key, known, handler)
+		if !ok {
+			return
+		}
+
+		err := watchEvents(ctx, cli, key, revision, known, handler)
+		if err == nil || ctx.Err() != nil {
+			// done
+			return
+		}
+		nl.Warn().Msg("etcd: watch canceled and retry now").String("key", key).Error("error", err).Fire()
+	}
+}
+
+// listExistsKeys get all exists keys with the specified prefix and notify them to handler.
+// The known keys that not exists anymore will be notified with EventDELETE, and then the
+// known will be replaced with the exists keys. The returned revision is the next revision
+// to watch from.
+func listExistsKeys(ctx context.Context, cli *Client, key string, known map[string]struct{}, handler func(ctx context.Context, eventType EventType, kv *KeyValue)) (revision int64, ok bool) {
+	nl := glog.FromContext(ctx)
+
+	rangeEnd := etcdv3.GetPrefixRangeEnd(key)
+	startKey := key
+
+	// Pin all list requests to the revision of first response to get a consistent snapshot.
+	snapshot := int64(0)
+	listed := make(map[string]struct{})
+	more := true
+	for more {
+		opts := []etcdv3.OpOption{etcdv3.WithRange(rangeEnd), etcdv3.WithLimit(watchListLimit)}
+		if snapshot != 0 {
+			opts = append(opts, etcdv3.WithRev(snapshot))
+		}
+
+		resp, err := cli.Get(ctx, startKey, opts...)
 		if err != nil {
-			if err == context.Canceled {
+			if ctx.Err() != nil {
 				nl.Warn().Msg("etcd: context canceled when get exists key, return now").Fire()
-				return
+				return 0, false
 			}
 			nl.Error().Msg("etcd: get exists keys failed, retry after 10s").String("key", key).Error("error", err).Fire()
-			time.Sleep(time.Second * 10)
+			select {
+			case <-time.After(time.Second * 10):
+			case <-ctx.Done():
+				return 0, false
+			}
+			// The snapshot revision may be compacted, starts from scratch.
+			snapshot = 0
+			startKey = key
+			// The keys listed have been notified, they must be checked after the list.
+			for k := range listed {
+				known[k] = struct{}{}
+			}
+			listed = make(map[string]struct{})
 			continue
 		}
 
 		for _, kv := range resp.Kvs {
+			listed[string(kv.Key)] = struct{}{}
 			handler(ctx, EventPUT, kv)
 		}
 
-		revision = resp.Header.Revision + 1
+		if snapshot == 0 {
+			snapshot = resp.Header.Revision
+		}
 		more = resp.More
+		if more && len(resp.Kvs) != 0 {
+			// Continues from the next key of the last one.
+			startKey = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		}
 	}
 
-	watchChan := cli.Watch(ctx, key, etcdv3.WithPrefix(), etcdv3.WithRev(revision))
-LOOP:
+	for k := range known {
+		if _, ok := listed[k]; !ok {
+			delete(known, k)
+			handler(ctx, EventDELETE, &KeyValue{Key: []byte(k), ModRevision: snapshot})
+		}
+	}
+	for k := range listed {
+		known[k] = struct{}{}
+	}
+	return snapshot + 1, true
+}
+
+// watchEvents watch the events since revision and notify them to handler, the known keys
+// will be updated with the events. It returns nil only if the ctx done.
+func watchEvents(ctx context.Context, cli *Client, key string, revision int64, known map[string]struct{}, handler func(ctx context.Context, eventType EventType, kv *KeyValue)) error {
+	ctxWatch, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watchChan := cli.Watch(etcdv3.WithRequireLeader(ctxWatch), key, etcdv3.WithPrefix(), etcdv3.WithRev(revision))
 	for {
 		select {
-		case resp := <-watchChan:
+		case resp, ok := <-watchChan:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errWatchClosed
+			}
+			if err := resp.Err(); err != nil {
+				return err
+			}
 			for _, event := range resp.Events {
 				switch event.Type {
 				case mvccpb.PUT:
+					known[string(event.Kv.Key)] = struct{}{}
 					handler(ctx, EventPUT, event.Kv)
 				case mvccpb.DELETE:
+					delete(known, string(event.Kv.Key))
 					handler(ctx, EventDELETE, event.Kv)
 				}
 			}
 		case <-ctx.Done():
 			// done
-			break LOOP
+			return nil
 		}
 	}
 }
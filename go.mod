@@ -7,6 +7,7 @@ require (
 	github.com/DataWorkbench/gproto v0.0.0-20230528154725-d7c1604edd8a
 	github.com/HdrHistogram/hdrhistogram-go v1.0.1 // indirect
 	github.com/Shopify/sarama v1.29.1
+	github.com/alicebob/miniredis/v2 v2.23.1
 	github.com/buger/jsonparser v1.1.1
 	github.com/creasty/defaults v1.5.1
 	github.com/dazheng/gohive v0.0.0-20190904024313-b1810177c8f2
@@ -18,11 +19,13 @@ require (
 	github.com/go-playground/validator/v10 v10.4.1
 	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/go-redis/redis/v8 v8.11.0
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.4.2
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
 	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0
 	github.com/grpc-ecosystem/grpc-opentracing v0.0.0-20180507213350-8e809c8a8645
+	github.com/jackc/pgconn v1.11.0
 	github.com/mailru/dbr v3.0.0+incompatible
 	github.com/mailru/go-clickhouse v1.8.0
 	github.com/mattn/go-sqlite3 v1.14.15 // indirect
@@ -33,7 +36,8 @@ require (
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1
 	github.com/pkg/sftp v1.13.4
-	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
 	github.com/samuel/go-zookeeper v0.0.0-20190923202752-2cc03de413da
 	github.com/satori/go.uuid v1.2.0
@@ -46,9 +50,10 @@ require (
 	github.com/uber/jaeger-lib v2.4.0+incompatible // indirect
 	github.com/yu31/protoc-plugin v0.0.0-20230528154456-c713541dce13
 	github.com/yu31/snowflake v0.0.0-20220217043813-1552fe47d479
-	go.etcd.io/etcd/api/v3 v3.5.1
-	go.etcd.io/etcd/client/v3 v3.5.0
-	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	go.etcd.io/etcd/api/v3 v3.5.5
+	go.etcd.io/etcd/client/v3 v3.5.5
+	golang.org/x/crypto v0.0.0-20220411220226-7b82a4e95df4
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	google.golang.org/grpc v1.48.0
 	google.golang.org/protobuf v1.27.1
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0 // indirect
@@ -57,5 +62,6 @@ require (
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	gorm.io/driver/mysql v1.3.2
 	gorm.io/driver/postgres v1.3.4
+	gorm.io/driver/sqlite v1.3.1
 	gorm.io/gorm v1.23.1
 )
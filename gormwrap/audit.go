@@ -0,0 +1,542 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/kafka"
+)
+
+const (
+	auditName      = "gormwrap:audit"
+	auditBeforeKey = "gormwrap:audit_before"
+)
+
+// DefaultAuditTable is the default table to record the audit events.
+const DefaultAuditTable = "audit_logs"
+
+// DefaultAuditMaxRows is the default max number of rows changed by an audited update or delete.
+const DefaultAuditMaxRows = 1000
+
+// The actions of audit events.
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// Auditable is implemented by the models that need audit trail.
+type Auditable interface {
+	// AuditOmitColumns returns the columns that are not recorded, such as the passwords.
+	AuditOmitColumns() []string
+}
+
+// AuditChange is the value of a column before and after changed.
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditChanges is the changed columns, it's stored as JSON.
+type AuditChanges map[string]*AuditChange
+
+// Scan implements the sql.Scanner interface.
+func (c *AuditChanges) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case nil:
+		*c = nil
+		return nil
+	}
+	return fmt.Errorf("gormwrap: unsupported type %T of AuditChanges", value)
+}
+
+// Value implements the driver.Valuer interface.
+func (c AuditChanges) Value() (driver.Value, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// AuditEvent is a change of Auditable model, it's also the model of audit table.
+//
+// The audit table can be created by db.Table(gormwrap.DefaultAuditTable).AutoMigrate(&gormwrap.AuditEvent{}).
+type AuditEvent struct {
+	ID         int64        `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Action     string       `gorm:"column:action;type:varchar(16);not null" json:"action"`
+	Resource   string       `gorm:"column:resource;type:varchar(64);not null;index:idx_audit_resource" json:"resource"`
+	ResourceID string       `gorm:"column:resource_id;type:varchar(128);not null;index:idx_audit_resource" json:"resource_id"`
+	Actor      string       `gorm:"column:actor;type:varchar(64);not null" json:"actor"`
+	TraceID    string       `gorm:"column:trace_id;type:varchar(64);not null" json:"trace_id"`
+	Changes    AuditChanges `gorm:"column:changes;type:text" json:"changes"`
+	Created    time.Time    `gorm:"column:created;not null" json:"created"`
+	// Published is set by AuditRelay after the event is published.
+	Published bool `gorm:"column:published;not null;default:false;index:idx_audit_published" json:"-"`
+}
+
+// AuditPublisher publishes the audit events to other systems.
+type AuditPublisher interface {
+	Publish(ctx context.Context, event *AuditEvent) error
+}
+
+type kafkaAuditPublisher struct {
+	producer kafka.Producer
+	topic    string
+}
+
+// NewKafkaAuditPublisher returns an AuditPublisher that sends the events as JSON to topic,
+// the resource id is used as the message key.
+func NewKafkaAuditPublisher(producer kafka.Producer, topic string) AuditPublisher {
+	return &kafkaAuditPublisher{producer: producer, topic: topic}
+}
+
+// Publish implements AuditPublisher.
+func (p *kafkaAuditPublisher) Publish(ctx context.Context, event *AuditEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.producer.Send(ctx, p.topic, kafka.StringEncoder(event.ResourceID), kafka.ByteEncoder(value))
+}
+
+type ctxActorKey struct{}
+
+// WithActor returns a new context with the user who makes the changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ctxActorKey{}, actor)
+}
+
+// ActorFromContext returns the actor in ctx, or an empty string if not found.
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(ctxActorKey{}).(string)
+	return actor
+}
+
+// AuditOption used to change the behavior of audit plugin.
+type AuditOption func(o *auditOptions)
+
+type auditOptions struct {
+	table     string
+	maxRows   int
+	interval  time.Duration
+	batchSize int
+}
+
+// WithAuditTable sets the table to record the audit events. Defaults DefaultAuditTable.
+func WithAuditTable(table string) AuditOption {
+	return func(o *auditOptions) {
+		o.table = table
+	}
+}
+
+// WithAuditMaxRows sets the max number of rows changed by an update or delete of Auditable models,
+// the statement is rejected if more rows matched since all of them are loaded to record the
+// changes. Defaults DefaultAuditMaxRows.
+func WithAuditMaxRows(n int) AuditOption {
+	return func(o *auditOptions) {
+		o.maxRows = n
+	}
+}
+
+// WithAuditRelayInterval sets the interval of AuditRelay to check the unpublished events.
+// Defaults 1s.
+func WithAuditRelayInterval(d time.Duration) AuditOption {
+	return func(o *auditOptions) {
+		o.interval = d
+	}
+}
+
+// WithAuditRelayBatchSize sets the max number of events published by AuditRelay in a batch.
+// Defaults 100.
+func WithAuditRelayBatchSize(n int) AuditOption {
+	return func(o *auditOptions) {
+		o.batchSize = n
+	}
+}
+
+func newAuditOptions(opts []AuditOption) auditOptions {
+	o := auditOptions{
+		table:     DefaultAuditTable,
+		maxRows:   DefaultAuditMaxRows,
+		interval:  time.Second,
+		batchSize: 100,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// auditPlugin implements gorm.Plugin to record the changes of Auditable models.
+type auditPlugin struct {
+	opts auditOptions
+}
+
+// NewAuditPlugin returns the gorm plugin that records the create, update and delete events of
+// Auditable models into the audit table, in the same transaction of the changes. Each event
+// contains the changed columns, the actor from ActorFromContext and the trace id from
+// gtrace.IdFromContext. The changes by raw SQL are not recorded.
+//
+// The rows to update or delete are loaded by SELECT ... FOR UPDATE before the changes, so the
+// concurrent changes of the same rows cannot be missed in the before values. The events are not
+// published by the plugin since the transaction may be rolled back, use AuditRelay to publish
+// them after committed.
+//
+// Usage: err := db.Use(gormwrap.NewAuditPlugin())
+func NewAuditPlugin(opts ...AuditOption) gorm.Plugin {
+	return &auditPlugin{opts: newAuditOptions(opts)}
+}
+
+// Name implements gorm.Plugin.
+func (p *auditPlugin) Name() string {
+	return auditName
+}
+
+// Initialize implements gorm.Plugin.
+func (p *auditPlugin) Initialize(db *gorm.DB) (err error) {
+	if err = db.Callback().Create().After("gorm:create").Register(auditName, p.afterCreate); err != nil {
+		return
+	}
+	if err = db.Callback().Update().Before("gorm:update").Register(auditName+":before", p.loadBefore); err != nil {
+		return
+	}
+	if err = db.Callback().Update().After("gorm:update").Register(auditName, p.afterUpdate); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().Before("gorm:delete").Register(auditName+":before", p.loadBefore); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().After("gorm:delete").Register(auditName, p.afterDelete); err != nil {
+		return
+	}
+	return
+}
+
+// auditable returns the omitted columns if the model is Auditable.
+func (p *auditPlugin) auditable(db *gorm.DB) (map[string]bool, bool) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil, false
+	}
+	model, ok := reflect.New(db.Statement.Schema.ModelType).Interface().(Auditable)
+	if !ok {
+		return nil, false
+	}
+	omits := make(map[string]bool)
+	for _, column := range model.AuditOmitColumns() {
+		omits[column] = true
+	}
+	return omits, true
+}
+
+func (p *auditPlugin) afterCreate(db *gorm.DB) {
+	omits, ok := p.auditable(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+
+	var events []*AuditEvent
+	addRow := func(rv reflect.Value) {
+		changes := make(AuditChanges)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || omits[field.DBName] {
+				continue
+			}
+			value, _ := field.ValueOf(stmt.Context, rv)
+			changes[field.DBName] = &AuditChange{After: value}
+		}
+		events = append(events, p.newEvent(db, AuditActionCreate, p.resourceIDOfStruct(db, rv), changes))
+	}
+
+	rv := reflect.Indirect(stmt.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			addRow(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		addRow(rv)
+	}
+	p.record(db, events)
+}
+
+// loadBefore loads the rows that will be updated or deleted.
+func (p *auditPlugin) loadBefore(db *gorm.DB) {
+	if _, ok := p.auditable(db); !ok {
+		return
+	}
+	exprs := p.conditions(db)
+	if len(exprs) == 0 {
+		// gorm rejects the global update and delete.
+		return
+	}
+	// Locks the rows until the transaction ends, so they are not changed by others before the
+	// update or delete. Fetches one more row to know whether it exceeds the max rows.
+	rows, err := p.queryRows(db, exprs, clause.Locking{Strength: "UPDATE"}, clause.Limit{Limit: p.opts.maxRows + 1})
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if len(rows) > p.opts.maxRows {
+		_ = db.AddError(fmt.Errorf("gormwrap: audit more than %d rows of %s in a statement", p.opts.maxRows, db.Statement.Table))
+		return
+	}
+	db.InstanceSet(auditBeforeKey, rows)
+}
+
+func (p *auditPlugin) afterUpdate(db *gorm.DB) {
+	omits, ok := p.auditable(db)
+	if !ok {
+		return
+	}
+	before := p.beforeRows(db)
+	if len(before) == 0 {
+		return
+	}
+	after, err := p.queryRows(db, []clause.Expression{p.primaryCondition(db, before)})
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	afterByID := make(map[string]map[string]interface{}, len(after))
+	for _, row := range after {
+		afterByID[p.resourceIDOfRow(db, row)] = row
+	}
+
+	var events []*AuditEvent
+	for _, b := range before {
+		id := p.resourceIDOfRow(db, b)
+		a, ok := afterByID[id]
+		if !ok {
+			continue
+		}
+		changes := make(AuditChanges)
+		for column, value := range a {
+			if omits[column] || reflect.DeepEqual(b[column], value) {
+				continue
+			}
+			changes[column] = &AuditChange{Before: b[column], After: value}
+		}
+		if len(changes) > 0 {
+			events = append(events, p.newEvent(db, AuditActionUpdate, id, changes))
+		}
+	}
+	p.record(db, events)
+}
+
+func (p *auditPlugin) afterDelete(db *gorm.DB) {
+	omits, ok := p.auditable(db)
+	if !ok {
+		return
+	}
+	events := make([]*AuditEvent, 0, len(p.beforeRows(db)))
+	for _, row := range p.beforeRows(db) {
+		changes := make(AuditChanges)
+		for column, value := range row {
+			if !omits[column] {
+				changes[column] = &AuditChange{Before: value}
+			}
+		}
+		events = append(events, p.newEvent(db, AuditActionDelete, p.resourceIDOfRow(db, row), changes))
+	}
+	p.record(db, events)
+}
+
+func (p *auditPlugin) beforeRows(db *gorm.DB) []map[string]interface{} {
+	v, ok := db.InstanceGet(auditBeforeKey)
+	if !ok {
+		return nil
+	}
+	rows, _ := v.([]map[string]interface{})
+	return rows
+}
+
+// conditions returns the WHERE conditions of statement with the primary keys of model, as gorm
+// adds them in update and delete.
+func (p *auditPlugin) conditions(db *gorm.DB) []clause.Expression {
+	stmt := db.Statement
+	var exprs []clause.Expression
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			exprs = append(exprs, where.Exprs...)
+		}
+	}
+
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if value, zero := field.ValueOf(stmt.Context, rv); !zero {
+				exprs = append(exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+			}
+		}
+	}
+	return exprs
+}
+
+// queryRows queries the rows of model by exprs in the same connection of statement.
+func (p *auditPlugin) queryRows(db *gorm.DB, exprs []clause.Expression, clauses ...clause.Expression) ([]map[string]interface{}, error) {
+	stmt := db.Statement
+	var rows []map[string]interface{}
+	err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Clauses(append(clauses, clause.Where{Exprs: exprs})...).
+		Find(&rows).Error
+	return rows, err
+}
+
+// primaryCondition returns the condition matches the rows by primary keys.
+func (p *auditPlugin) primaryCondition(db *gorm.DB, rows []map[string]interface{}) clause.Expression {
+	ors := make([]clause.Expression, 0, len(rows))
+	for _, row := range rows {
+		ands := make([]clause.Expression, 0, len(db.Statement.Schema.PrimaryFields))
+		for _, field := range db.Statement.Schema.PrimaryFields {
+			ands = append(ands, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: row[field.DBName]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+	return clause.Or(ors...)
+}
+
+func (p *auditPlugin) resourceIDOfRow(db *gorm.DB, row map[string]interface{}) string {
+	ids := make([]string, 0, len(db.Statement.Schema.PrimaryFields))
+	for _, field := range db.Statement.Schema.PrimaryFields {
+		ids = append(ids, auditValueString(row[field.DBName]))
+	}
+	return strings.Join(ids, ",")
+}
+
+func (p *auditPlugin) resourceIDOfStruct(db *gorm.DB, rv reflect.Value) string {
+	ids := make([]string, 0, len(db.Statement.Schema.PrimaryFields))
+	for _, field := range db.Statement.Schema.PrimaryFields {
+		value, _ := field.ValueOf(db.Statement.Context, rv)
+		ids = append(ids, auditValueString(value))
+	}
+	return strings.Join(ids, ",")
+}
+
+func (p *auditPlugin) newEvent(db *gorm.DB, action string, resourceID string, changes AuditChanges) *AuditEvent {
+	ctx := db.Statement.Context
+	return &AuditEvent{
+		Action:     action,
+		Resource:   db.Statement.Table,
+		ResourceID: resourceID,
+		Actor:      ActorFromContext(ctx),
+		TraceID:    gtrace.IdFromContext(ctx),
+		Changes:    changes,
+		Created:    time.Now(),
+	}
+}
+
+// record inserts the events in the same connection of statement.
+func (p *auditPlugin) record(db *gorm.DB, events []*AuditEvent) {
+	if len(events) == 0 {
+		return
+	}
+	// Keeps the events in order of resource id.
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ResourceID < events[j].ResourceID })
+
+	err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(p.opts.table).Create(&events).Error
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("gormwrap: record audit events: %w", err))
+	}
+}
+
+// AuditRelay publishes the events in audit table that are recorded by the audit plugin. Only the
+// committed events can be read, so the events of rolled back transactions are never published.
+//
+// The events are published at least once in order of id, and marked published after success.
+// The event may be published again if failed to mark it, or there are multiple relays of the
+// same table, so the consumers should dedup the events by id.
+type AuditRelay struct {
+	db        *gorm.DB
+	publisher AuditPublisher
+	opts      auditOptions
+}
+
+// NewAuditRelay returns an AuditRelay that publishes the events by publisher, such as
+// NewKafkaAuditPublisher. The WithAuditTable and WithAuditRelay* options are used.
+//
+// Usage: go gormwrap.NewAuditRelay(db, publisher).Run(ctx)
+func NewAuditRelay(db *gorm.DB, publisher AuditPublisher, opts ...AuditOption) *AuditRelay {
+	return &AuditRelay{db: db, publisher: publisher, opts: newAuditOptions(opts)}
+}
+
+// Run publishes the unpublished events every interval until ctx done, the errors are logged.
+//
+// NOTICE: Must set glog.Logger into the ctx by glog.WithContext
+func (r *AuditRelay) Run(ctx context.Context) {
+	lp := glog.FromContext(ctx)
+	ticker := time.NewTicker(r.opts.interval)
+	defer ticker.Stop()
+	for {
+		// Publishes the next batch immediately if the batch is full.
+		n, err := r.PublishPending(ctx)
+		if err != nil {
+			lp.Error().Error("gorm: publish audit events error", err).Fire()
+		}
+		if err == nil && n == r.opts.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending publishes a batch of the unpublished events in order of id and returns the
+// number of published events. It stops at the first error of publisher.
+func (r *AuditRelay) PublishPending(ctx context.Context) (int, error) {
+	// Reads from the primary, the events are marked published on it.
+	db := r.db.WithContext(UsePrimary(ctx)).Table(r.opts.table)
+
+	var events []*AuditEvent
+	if err := db.Where("published = ?", false).Order("id").Limit(r.opts.batchSize).Find(&events).Error; err != nil {
+		return 0, err
+	}
+	ids := make([]int64, 0, len(events))
+	var perr error
+	for _, event := range events {
+		if perr = r.publisher.Publish(ctx, event); perr != nil {
+			perr = fmt.Errorf("gormwrap: publish audit event %d: %w", event.ID, perr)
+			break
+		}
+		ids = append(ids, event.ID)
+	}
+	if len(ids) > 0 {
+		if err := db.Where("id IN ?", ids).Update("published", true).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), perr
+}
+
+func auditValueString(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
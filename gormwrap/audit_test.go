@@ -0,0 +1,175 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/qerror"
+)
+
+type auditDatasource struct {
+	ID       string `gorm:"primaryKey"`
+	Name     string
+	Password string
+	SoftDelete
+}
+
+func (auditDatasource) AuditOmitColumns() []string {
+	return []string{"password"}
+}
+
+type auditPublisher struct {
+	events []*AuditEvent
+	err    error
+}
+
+func (p *auditPublisher) Publish(_ context.Context, event *AuditEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestAuditPlugin(t *testing.T) {
+	db := openTestSQLite(t, "audit.db")
+	require.Nil(t, db.AutoMigrate(&auditDatasource{}))
+	require.Nil(t, db.Table(DefaultAuditTable).AutoMigrate(&AuditEvent{}))
+	require.Nil(t, db.Use(NewAuditPlugin(WithAuditMaxRows(2))))
+
+	ctx := gtrace.ContextWithId(WithActor(context.Background(), "usr-1"), "tid-1")
+	tx := db.WithContext(ctx)
+
+	require.Nil(t, tx.Create(&auditDatasource{ID: "som-1", Name: "foo", Password: "secret"}).Error)
+	require.Nil(t, tx.Model(&auditDatasource{ID: "som-1"}).Updates(map[string]interface{}{"name": "bar", "password": "new"}).Error)
+	// No changes.
+	require.Nil(t, tx.Model(&auditDatasource{}).Where("id = ?", "som-1").Update("name", "bar").Error)
+	require.Nil(t, tx.Delete(&auditDatasource{ID: "som-1"}).Error)
+
+	var events []*AuditEvent
+	require.Nil(t, db.Table(DefaultAuditTable).Order("id").Find(&events).Error)
+	require.Len(t, events, 3)
+
+	for _, event := range events {
+		require.Equal(t, "audit_datasources", event.Resource)
+		require.Equal(t, "som-1", event.ResourceID)
+		require.Equal(t, "usr-1", event.Actor)
+		require.Equal(t, "tid-1", event.TraceID)
+		require.NotContains(t, event.Changes, "password")
+	}
+
+	require.Equal(t, AuditActionCreate, events[0].Action)
+	require.Equal(t, "foo", events[0].Changes["name"].After)
+
+	require.Equal(t, AuditActionUpdate, events[1].Action)
+	require.Len(t, events[1].Changes, 1)
+	require.Equal(t, &AuditChange{Before: "foo", After: "bar"}, events[1].Changes["name"])
+
+	require.Equal(t, AuditActionDelete, events[2].Action)
+	require.Equal(t, "bar", events[2].Changes["name"].Before)
+
+	// Soft deleted.
+	var ds auditDatasource
+	require.Equal(t, qerror.ResourceHasBeenDeleted.Format("datasource", "som-1"), TakeResource(tx, &ds, "datasource", "som-1"))
+	require.Equal(t, "bar", ds.Name)
+	require.Equal(t, qerror.ResourceNotExists.Format("som-2"), TakeResource(tx, &ds, "datasource", "som-2"))
+	require.True(t, errors.Is(tx.Take(&ds, "id = ?", "som-1").Error, gorm.ErrRecordNotFound))
+
+	// The audit events are rolled back with the changes.
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&auditDatasource{ID: "som-2", Name: "foo"}).Error; err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	require.NotNil(t, err)
+	var count int64
+	require.Nil(t, db.Table(DefaultAuditTable).Count(&count).Error)
+	require.Equal(t, int64(3), count)
+
+	// Rejects the statement changes too many rows.
+	require.Nil(t, tx.Create([]*auditDatasource{{ID: "som-3"}, {ID: "som-4"}, {ID: "som-5"}}).Error)
+	require.NotNil(t, tx.Where("id <> ?", "").Delete(&auditDatasource{}).Error)
+	require.Nil(t, tx.Take(&auditDatasource{}, "id = ?", "som-5").Error)
+}
+
+func TestAuditRelay(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	db := openTestSQLite(t, "audit.db")
+	require.Nil(t, db.AutoMigrate(&auditDatasource{}))
+	require.Nil(t, db.Table(DefaultAuditTable).AutoMigrate(&AuditEvent{}))
+	require.Nil(t, db.Use(NewAuditPlugin()))
+	tx := db.WithContext(ctx)
+
+	publisher := &auditPublisher{err: errors.New("unavailable")}
+	relay := NewAuditRelay(db, publisher, WithAuditRelayBatchSize(2))
+
+	require.Nil(t, tx.Create(&auditDatasource{ID: "som-1", Name: "foo"}).Error)
+	require.NotNil(t, tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&auditDatasource{ID: "som-2", Name: "foo"}).Error; err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	}))
+	require.Nil(t, tx.Model(&auditDatasource{ID: "som-1"}).Update("name", "bar").Error)
+	require.Nil(t, tx.Delete(&auditDatasource{ID: "som-1"}).Error)
+
+	// Not published until the publisher recovers.
+	n, err := relay.PublishPending(ctx)
+	require.NotNil(t, err)
+	require.Equal(t, 0, n)
+
+	publisher.err = nil
+	n, err = relay.PublishPending(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	n, err = relay.PublishPending(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	n, err = relay.PublishPending(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+
+	// The events of rolled back transaction are never published.
+	require.Len(t, publisher.events, 3)
+	for i, action := range []string{AuditActionCreate, AuditActionUpdate, AuditActionDelete} {
+		require.Equal(t, action, publisher.events[i].Action)
+		require.Equal(t, "som-1", publisher.events[i].ResourceID)
+	}
+
+	// Run publishes the new events.
+	require.Nil(t, tx.Create(&auditDatasource{ID: "som-3", Name: "foo"}).Error)
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		NewAuditRelay(db, publisher, WithAuditRelayInterval(time.Millisecond*10)).Run(runCtx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		var count int64
+		require.Nil(t, db.Table(DefaultAuditTable).Where("published = ?", false).Count(&count).Error)
+		return count == 0
+	}, time.Second*5, time.Millisecond*10)
+	cancel()
+	<-done
+	require.Len(t, publisher.events, 4)
+}
+
+func TestAuditLockBefore(t *testing.T) {
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db := newDryRunMySQL(t)
+	require.Nil(t, db.Use(NewAuditPlugin()))
+	db = db.Session(&gorm.Session{Logger: recorder, SkipDefaultTransaction: true})
+
+	require.Nil(t, db.Delete(&auditDatasource{ID: "som-1"}).Error)
+	require.Equal(t, "SELECT * FROM `audit_datasources` WHERE `audit_datasources`.`id` = 'som-1' AND "+
+		"`audit_datasources`.`deleted` IS NULL LIMIT 1001 FOR UPDATE", recorder.statements[0])
+}
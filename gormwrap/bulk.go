@@ -0,0 +1,114 @@
+package gormwrap
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The max number of placeholders in a statement of drivers.
+const (
+	maxPlaceholders       = 65535
+	maxSQLitePlaceholders = 32766
+)
+
+// BulkUpsert inserts the rows, or updates the updateColumns of the rows that conflict on the
+// conflictColumns. The rows are ignored on conflict if updateColumns is empty. It returns the
+// number of affected rows reported by the database, notice that MySQL reports 2 for each row
+// updated and 0 for each row unchanged.
+//
+// The rows must be a slice of model, they're written by chunks of batchSize rows in a
+// transaction, and batchSize is reduced to keep each statement under the placeholder limit of
+// the database. The conflictColumns are ignored by MySQL, which uses `ON DUPLICATE KEY UPDATE`
+// for any unique key, and default to the primary key of model for the others. If there is a transaction in ctx (see ExecuteFuncWithRetryTxn), it's used.
+//
+// Usage:
+//
+//	affected, err := gormwrap.BulkUpsert(ctx, db, counts, []string{"space_id"}, constants.DispatchedColumns, 500)
+func BulkUpsert(ctx context.Context, db *gorm.DB, rows interface{}, conflictColumns []string, updateColumns []string, batchSize int) (int64, error) {
+	rv := reflect.Indirect(reflect.ValueOf(rows))
+	if rv.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("gormwrap: BulkUpsert requires a slice of rows, got %T", rows)
+	}
+	if rv.Len() == 0 {
+		return 0, nil
+	}
+
+	conn := DBFromContext(ctx, db)
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(rows); err != nil {
+		return 0, err
+	}
+	dialect := conn.Dialector.Name()
+	if len(conflictColumns) == 0 && len(updateColumns) > 0 && dialect != DriverMySQL {
+		// The conflict target is required by `ON CONFLICT DO UPDATE`.
+		conflictColumns = stmt.Schema.PrimaryFieldDBNames
+		if len(conflictColumns) == 0 {
+			return 0, fmt.Errorf("gormwrap: BulkUpsert requires conflict columns, %s has no primary key", stmt.Schema.Name)
+		}
+	}
+
+	onConflict := clause.OnConflict{DoNothing: len(updateColumns) == 0}
+	for _, column := range conflictColumns {
+		if !columnNameRegexp.MatchString(column) {
+			return 0, fmt.Errorf("gormwrap: invalid column name %q", column)
+		}
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	for _, column := range updateColumns {
+		if !columnNameRegexp.MatchString(column) {
+			return 0, fmt.Errorf("gormwrap: invalid column name %q", column)
+		}
+	}
+	if len(updateColumns) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+	}
+
+	batchSize = upsertBatchSize(dialect, len(stmt.Schema.DBNames), batchSize)
+
+	var affected int64
+	write := func(tx *gorm.DB) error {
+		for i := 0; i < rv.Len(); i += batchSize {
+			j := i + batchSize
+			if j > rv.Len() {
+				j = rv.Len()
+			}
+			result := tx.Clauses(onConflict).Create(rv.Slice(i, j).Interface())
+			if result.Error != nil {
+				return result.Error
+			}
+			affected += result.RowsAffected
+		}
+		return nil
+	}
+
+	var err error
+	if conn.DryRun {
+		// No transaction to only generate the statements.
+		err = write(conn.Session(&gorm.Session{SkipDefaultTransaction: true}))
+	} else {
+		err = conn.Transaction(write)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
+// upsertBatchSize returns the number of rows in a statement under the placeholder limit.
+func upsertBatchSize(dialect string, columns int, batchSize int) int {
+	limit := maxPlaceholders
+	if dialect == DriverSQLite {
+		limit = maxSQLitePlaceholders
+	}
+	if columns < 1 {
+		columns = 1
+	}
+	if maxRows := limit / columns; batchSize <= 0 || batchSize > maxRows {
+		batchSize = maxRows
+	}
+	return batchSize
+}
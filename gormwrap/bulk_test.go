@@ -0,0 +1,99 @@
+package gormwrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type bulkTaskCount struct {
+	SpaceID       string `gorm:"primaryKey"`
+	FlowCount     int64
+	InstanceCount int64
+	Updated       int64
+}
+
+func TestBulkUpsert(t *testing.T) {
+	db := openTestSQLite(t, "bulk.db")
+	require.Nil(t, db.AutoMigrate(&bulkTaskCount{}))
+	ctx := context.Background()
+	columns := []string{"flow_count", "instance_count", "updated"}
+
+	rows := make([]*bulkTaskCount, 5)
+	for i := range rows {
+		rows[i] = &bulkTaskCount{SpaceID: "wks-" + string(rune('a'+i)), FlowCount: 1, InstanceCount: 1, Updated: 1}
+	}
+	affected, err := BulkUpsert(ctx, db, rows, []string{"space_id"}, columns, 2)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), affected)
+
+	rows = []*bulkTaskCount{
+		{SpaceID: "wks-a", FlowCount: 2, InstanceCount: 3, Updated: 2},
+		{SpaceID: "wks-f", FlowCount: 1, InstanceCount: 1, Updated: 2},
+	}
+	affected, err = BulkUpsert(ctx, db, rows, []string{"space_id"}, columns, 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), affected)
+
+	var got []*bulkTaskCount
+	require.Nil(t, db.Order("space_id").Find(&got).Error)
+	require.Len(t, got, 6)
+	require.Equal(t, bulkTaskCount{SpaceID: "wks-a", FlowCount: 2, InstanceCount: 3, Updated: 2}, *got[0])
+	require.Equal(t, int64(1), got[1].FlowCount)
+
+	// Ignored on conflict.
+	_, err = BulkUpsert(ctx, db, []bulkTaskCount{{SpaceID: "wks-a", FlowCount: 9}}, []string{"space_id"}, nil, 10)
+	require.Nil(t, err)
+	require.Nil(t, db.Take(got[0], "space_id = ?", "wks-a").Error)
+	require.Equal(t, int64(2), got[0].FlowCount)
+
+	_, err = BulkUpsert(ctx, db, rows, []string{"space_id; --"}, columns, 10)
+	require.NotNil(t, err)
+	_, err = BulkUpsert(ctx, db, rows[0], []string{"space_id"}, columns, 10)
+	require.NotNil(t, err)
+}
+
+func TestBulkUpsertMySQL(t *testing.T) {
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db := newDryRunMySQL(t).Session(&gorm.Session{Logger: recorder})
+
+	rows := []*bulkTaskCount{{SpaceID: "wks-a"}, {SpaceID: "wks-b"}, {SpaceID: "wks-c"}}
+	_, err := BulkUpsert(context.Background(), db, rows, []string{"space_id"}, []string{"flow_count", "updated"}, 2)
+	require.Nil(t, err)
+	require.Len(t, recorder.statements, 2)
+	require.Contains(t, recorder.statements[0], "VALUES ('wks-a',0,0,0),('wks-b',0,0,0) "+
+		"ON DUPLICATE KEY UPDATE `flow_count`=VALUES(`flow_count`),`updated`=VALUES(`updated`)")
+}
+
+func TestBulkUpsertPostgres(t *testing.T) {
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 user=user dbname=test"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               recorder,
+	})
+	require.Nil(t, err)
+
+	rows := []*bulkTaskCount{{SpaceID: "wks-a"}, {SpaceID: "wks-b"}}
+	_, err = BulkUpsert(context.Background(), db, rows, []string{"space_id"}, []string{"flow_count", "updated"}, 0)
+	require.Nil(t, err)
+	require.Len(t, recorder.statements, 1)
+	require.Contains(t, recorder.statements[0], `VALUES ('wks-a',0,0,0),('wks-b',0,0,0) `+
+		`ON CONFLICT ("space_id") DO UPDATE SET "flow_count"="excluded"."flow_count","updated"="excluded"."updated"`)
+
+	// The conflict columns default to the primary key.
+	_, err = BulkUpsert(context.Background(), db, rows, nil, []string{"flow_count"}, 0)
+	require.Nil(t, err)
+	require.Len(t, recorder.statements, 2)
+	require.Contains(t, recorder.statements[1], `ON CONFLICT ("space_id") DO UPDATE SET "flow_count"="excluded"."flow_count"`)
+}
+
+func TestUpsertBatchSize(t *testing.T) {
+	require.Equal(t, 500, upsertBatchSize(DriverMySQL, 10, 500))
+	require.Equal(t, 6553, upsertBatchSize(DriverMySQL, 10, 10000))
+	require.Equal(t, 3276, upsertBatchSize(DriverSQLite, 10, 0))
+}
@@ -4,33 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"go/types"
+	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/DataWorkbench/glog"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
 	"github.com/DataWorkbench/common/gtrace"
 )
 
+// The drivers supported by NewConn.
 const (
-	CondEqual         = " = ? "
-	CondNotEqual      = " <> ? "
-	CondIn            = " IN ? "
-	CondLike          = " LIKE ? "
-	CondLikePlacehold = "%"
-
-	JointAnd   = " AND "
-	ReverseFmt = "%s DESC"
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
-type MySQLConfig struct {
+type Config struct {
+	// Driver is one of "mysql", "postgres" and "sqlite".
+	Driver string `json:"driver" yaml:"driver" env:"DRIVER,default=mysql" validate:"omitempty,oneof=mysql postgres sqlite"`
 	// Hosts sample "127.0.0.1:3306,127.0.0.1:3307,127.0.0.1:3308"
-	Hosts       string `json:"hosts"         yaml:"hosts"         env:"HOSTS"                     validate:"required"`
-	Users       string `json:"users"         yaml:"users"         env:"USERS"                     validate:"required"`
-	Password    string `json:"password"      yaml:"password"      env:"PASSWORD"                  validate:"required"`
+	// The first host is the primary and the others are read-only replicas. Ignored by sqlite.
+	Hosts    string `json:"hosts"    yaml:"hosts"    env:"HOSTS"    validate:"required_unless=Driver sqlite"`
+	Users    string `json:"users"    yaml:"users"    env:"USERS"    validate:"required_unless=Driver sqlite"`
+	Password string `json:"password" yaml:"password" env:"PASSWORD" validate:"required_unless=Driver sqlite"`
+	// Database is the file path for sqlite.
 	Database    string `json:"database"      yaml:"database"      env:"DATABASE"                  validate:"required"`
 	MaxIdleConn int    `json:"max_idle_conn" yaml:"max_idle_conn" env:"MAX_IDLE_CONN,default=16"  validate:"required"`
 	MaxOpenConn int    `json:"max_open_conn" yaml:"max_open_conn" env:"MAX_OPEN_CONN,default=128" validate:"required"`
@@ -40,40 +43,86 @@ type MySQLConfig struct {
 	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME,default=10m" validate:"required"`
 	// SlowThreshold time 0 indicates disabled
 	SlowThreshold time.Duration `json:"slow_threshold" yaml:"slow_threshold" env:"SLOW_THRESHOLD,default=2s" validate:"gte=0"`
+	// ExplainSampleRate is the ratio of slow SELECTs to run EXPLAIN and log the plan. 0 indicates disabled
+	ExplainSampleRate float64 `json:"explain_sample_rate" yaml:"explain_sample_rate" env:"EXPLAIN_SAMPLE_RATE,default=0" validate:"gte=0,lte=1"`
+	// ExplainInterval is the min interval to explain the slow queries with same fingerprint
+	ExplainInterval time.Duration `json:"explain_interval" yaml:"explain_interval" env:"EXPLAIN_INTERVAL,default=1m" validate:"gte=0"`
+	// HealthCheckInterval is the interval to ping the replicas. 0 indicates 10s
+	HealthCheckInterval time.Duration `json:"health_check_interval" yaml:"health_check_interval" env:"HEALTH_CHECK_INTERVAL,default=10s" validate:"gte=0"`
+	// Name identifies the connection in metrics, defaults the Database.
+	Name string `json:"name" yaml:"name" env:"NAME"`
 }
 
-// NewMySQLConn return a grom.DB by mysql driver
+// MySQLConfig is the Config of mysql.
+//
+// Deprecated: Use Config instead.
+type MySQLConfig = Config
+
+// NewMySQLConn return a grom.DB by mysql driver, the cfg.Driver is ignored.
+//
 // NOTICE: Must set glog.Logger into the ctx by glow.WithContext
 func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error) {
+	c := *cfg
+	c.Driver = DriverMySQL
+	return NewConn(ctx, &c)
+}
+
+// NewConn return a grom.DB by the cfg.Driver, mysql is used if the driver is empty.
+//
+// If there are multiple hosts, the queries out of transaction are sent to the replicas.
+// The replicas are checked every HealthCheckInterval until closed by CloseConn.
+// The errors of driver such as duplicate key and deadlock are translated to ErrDuplicatedKey,
+// ErrDeadlock, etc.
+// NOTICE: Must set glog.Logger into the ctx by glow.WithContext
+func NewConn(ctx context.Context, cfg *Config) (db *gorm.DB, err error) {
 	lp := glog.FromContext(ctx)
+	closer := &connCloser{}
 
 	defer func() {
 		if err != nil {
-			lp.Error().Error("gorm: create mysql connection error", err).Fire()
+			lp.Error().String("driver", cfg.Driver).Error("gorm: create connection error", err).Fire()
+			_ = closer.close()
 			db = nil
 		}
 	}()
 
-	lp.Info().Msg("gorm: connecting to mysql").String("hosts", cfg.Hosts).String("database", cfg.Database).Fire()
+	driver := cfg.Driver
+	if driver == "" {
+		driver = DriverMySQL
+	}
 
-	hosts := strings.Split(strings.ReplaceAll(cfg.Hosts, " ", ""), ",")
-	if len(hosts) == 0 {
-		err = fmt.Errorf("invalid hosts %s", cfg.Hosts)
-		return
+	lp.Info().Msg("gorm: connecting to database").String("driver", driver).String("hosts", cfg.Hosts).String("database", cfg.Database).Fire()
+
+	var hosts []string
+	if driver != DriverSQLite {
+		hosts = strings.Split(strings.ReplaceAll(cfg.Hosts, " ", ""), ",")
+		if len(hosts) == 0 || hosts[0] == "" {
+			err = fmt.Errorf("invalid hosts %s", cfg.Hosts)
+			return
+		}
 	}
 
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		cfg.Users, cfg.Password, hosts[0], cfg.Database,
-	)
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverMySQL:
+		dialector = mysql.Open(buildDSN(driver, cfg, hosts[0]))
+	case DriverPostgres:
+		dialector = postgres.Open(buildDSN(driver, cfg, hosts[0]))
+	case DriverSQLite:
+		dialector = sqlite.Open(cfg.Database)
+	default:
+		err = fmt.Errorf("unsupported driver %s", cfg.Driver)
+		return
+	}
 
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
+	gormLogger := &Logger{
+		Level:         LogLevel(cfg.LogLevel),
+		SlowThreshold: cfg.SlowThreshold,
+		Output:        lp,
+	}
+	db, err = gorm.Open(dialector, &gorm.Config{
 		//SkipDefaultTransaction: true,
-		Logger: &Logger{
-			Level:         LogLevel(cfg.LogLevel),
-			SlowThreshold: cfg.SlowThreshold,
-			Output:        lp,
-		},
+		Logger: gormLogger,
 	})
 	if err != nil {
 		return
@@ -84,6 +133,13 @@ func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error
 	if sqlDB, err = db.DB(); err != nil {
 		return
 	}
+	closer.add(func() error {
+		dbStats.remove(sqlDB)
+		return sqlDB.Close()
+	})
+	if err = db.Use(closer); err != nil {
+		return
+	}
 	sqlDB.SetMaxIdleConns(cfg.MaxIdleConn)
 	sqlDB.SetMaxOpenConns(cfg.MaxOpenConn)
 	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
@@ -92,77 +148,185 @@ func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error
 	if err = db.Use(newOpenTracingPlugin(tracer)); err != nil {
 		return
 	}
+	if err = db.Use(newErrorTranslatorPlugin()); err != nil {
+		return
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Database
+	}
+	if err = db.Use(newMetricsPlugin(name)); err != nil {
+		return
+	}
+
+	var replicas []*replica
+	if len(hosts) > 1 {
+		replicas = make([]*replica, 0, len(hosts)-1)
+		for _, host := range hosts[1:] {
+			var rdb *sql.DB
+			if rdb, err = sql.Open(sqlDriverNames[driver], buildDSN(driver, cfg, host)); err != nil {
+				return
+			}
+			rdb.SetMaxIdleConns(cfg.MaxIdleConn)
+			rdb.SetMaxOpenConns(cfg.MaxOpenConn)
+			rdb.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+			replicas = append(replicas, &replica{host: host, db: rdb})
+			closer.add(func() error {
+				dbStats.remove(rdb)
+				return rdb.Close()
+			})
+			dbStats.add(name, host, rdb)
+		}
+		if err = useResolver(ctx, db, lp, replicas, cfg.HealthCheckInterval); err != nil {
+			return
+		}
+	}
+
+	primaryHost := cfg.Database
+	if driver != DriverSQLite {
+		primaryHost = hosts[0]
+	}
+
+	if cfg.ExplainSampleRate > 0 && cfg.SlowThreshold > 0 {
+		// The separate pools to explain the slow queries of primary and replicas.
+		pools := make(map[*sql.DB]*sql.DB, len(replicas)+1)
+		var edb *sql.DB
+		if edb, err = openExplainDB(driver, cfg, primaryHost); err != nil {
+			return
+		}
+		closer.add(edb.Close)
+		pools[sqlDB] = edb
+		for _, r := range replicas {
+			if edb, err = openExplainDB(driver, cfg, r.host); err != nil {
+				return
+			}
+			closer.add(edb.Close)
+			pools[r.db] = edb
+		}
+		explain := NewExplainPlugin(lp, cfg.SlowThreshold, WithExplainDB(pools),
+			WithExplainSampleRate(cfg.ExplainSampleRate), WithExplainInterval(cfg.ExplainInterval))
+		if err = db.Use(explain); err != nil {
+			return
+		}
+	}
 
-	// TODO: Adds multiple databases if necessary
-	// import gorm.io/plugin/dbresolver
+	dbStats.add(name, primaryHost, sqlDB)
 	return
 }
 
-type Condition struct {
-	// where cond: k = v
-	Values    map[string]interface{}
-	Operators map[string]string
-
-	Offset  int
-	Limit   int
-	Order   string
-	Reverse bool
+// openExplainDB opens a single connection pool to host to explain the slow queries.
+func openExplainDB(driver string, cfg *Config, host string) (*sql.DB, error) {
+	dsn := cfg.Database
+	if driver != DriverSQLite {
+		dsn = buildDSN(driver, cfg, host)
+	}
+	edb, err := sql.Open(sqlDriverNames[driver], dsn)
+	if err != nil {
+		return nil, err
+	}
+	edb.SetMaxOpenConns(1)
+	edb.SetMaxIdleConns(1)
+	edb.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return edb, nil
 }
 
-// default operator is CondEqual
-func (c *Condition) Update(column string, value interface{}, operator ...string) {
-	c.Values[column] = value
-	if len(operator) > 0 {
-		c.Operators[column] = operator[0]
-	} else {
-		switch value.(type) {
-		case types.Slice:
-			c.Operators[column] = CondIn
-		case types.Array:
-			c.Operators[column] = CondIn
-		default:
-			c.Operators[column] = CondEqual
-		}
+// CloseConn closes the primary and replicas of db created by NewConn, and stops the health check
+// and metrics of them. It's same as closing the db.DB() if db is not created by NewConn.
+func CloseConn(db *gorm.DB) error {
+	if closer, ok := db.Config.Plugins[connCloserName].(*connCloser); ok {
+		return closer.close()
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Close()
+}
+
+const connCloserName = "gormwrap:closer"
+
+// connCloser implements gorm.Plugin to keep the resources of connection created by NewConn.
+type connCloser struct {
+	mu      sync.Mutex
+	closers []func() error
+}
+
+// Name implements gorm.Plugin.
+func (c *connCloser) Name() string {
+	return connCloserName
+}
+
+// Initialize implements gorm.Plugin.
+func (c *connCloser) Initialize(*gorm.DB) error {
+	return nil
 }
 
-func (c *Condition) UpdateLimit(offset, limit int) {
-	c.Offset = offset
-	c.Limit = limit
+func (c *connCloser) add(f func() error) {
+	c.mu.Lock()
+	c.closers = append(c.closers, f)
+	c.mu.Unlock()
+}
+
+// close releases the resources in reverse order of added and returns the first error.
+func (c *connCloser) close() (err error) {
+	c.mu.Lock()
+	closers := c.closers
+	c.closers = nil
+	c.mu.Unlock()
+	for i := len(closers) - 1; i >= 0; i-- {
+		if cerr := closers[i](); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
 }
 
-func (c *Condition) UpdateOrder(order string, reverse bool) {
-	c.Order = order
-	c.Reverse = reverse
+// sqlDriverNames is the names of database/sql driver used by gorm dialectors.
+var sqlDriverNames = map[string]string{
+	DriverMySQL:    "mysql",
+	DriverPostgres: "pgx",
+	DriverSQLite:   "sqlite3",
 }
 
-// conditions: column: values(string, int or slice)
-func (c *Condition) Build(tx *gorm.DB) *gorm.DB {
-	var q, joint string
-	var a []interface{}
-	for column, v := range c.Values {
-		q += joint + column + c.Operators[column]
-		// handle like
-		if c.Operators[column] == CondLike {
-			a = append(a, fmt.Sprintf("%s%v%s", CondLikePlacehold, v, CondLikePlacehold))
-		} else {
-			a = append(a, v)
+// buildDSN returns the data source name of host.
+func buildDSN(driver string, cfg *Config, host string) string {
+	if driver == DriverPostgres {
+		h, port, err := net.SplitHostPort(host)
+		if err != nil {
+			h, port = host, "5432"
 		}
-		joint = JointAnd
+		return fmt.Sprintf("user=%s password=%s host=%s port=%s dbname=%s",
+			quotePostgresValue(cfg.Users), quotePostgresValue(cfg.Password), quotePostgresValue(h),
+			quotePostgresValue(port), quotePostgresValue(cfg.Database))
 	}
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", cfg.Users, cfg.Password, host, cfg.Database)
+}
+
+// postgresValueEscaper escapes the backslash and single quote in the value of postgres DSN.
+var postgresValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
 
-	if q != "" {
-		tx = tx.Where(q, a...)
+// quotePostgresValue quotes the value in keyword/value format of postgres DSN.
+func quotePostgresValue(value string) string {
+	return "'" + postgresValueEscaper.Replace(value) + "'"
+}
+
+// useResolver installs the resolverPlugin and starts the health check of replicas.
+func useResolver(ctx context.Context, db *gorm.DB, lp *glog.Logger, replicas []*replica, interval time.Duration) error {
+	resolver := newResolverPlugin(lp, replicas)
+	if err := db.Use(resolver); err != nil {
+		return err
 	}
-	if c.Limit > 0 {
-		tx = tx.Offset(c.Offset).Limit(c.Limit)
+	resolver.checkHealth(ctx, time.Second*5)
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
 	}
-	if c.Order != "" {
-		if c.Reverse {
-			tx = tx.Order(fmt.Sprintf(ReverseFmt, c.Order))
-		} else {
-			tx = tx.Order(c.Order)
-		}
+	if closer, ok := db.Config.Plugins[connCloserName].(*connCloser); ok {
+		closer.add(func() error {
+			resolver.stop()
+			return nil
+		})
 	}
-	return tx
+	go resolver.healthCheckLoop(interval)
+	return nil
 }
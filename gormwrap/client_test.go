@@ -0,0 +1,176 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type connUser struct {
+	ID   string `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex"`
+}
+
+func TestNewConnSQLite(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	db, err := NewConn(ctx, &Config{
+		Driver:          DriverSQLite,
+		Database:        filepath.Join(t.TempDir(), "conn.db"),
+		MaxIdleConn:     2,
+		MaxOpenConn:     4,
+		LogLevel:        int(SilentLevel),
+		ConnMaxLifetime: time.Minute,
+	})
+	require.Nil(t, err)
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+	defer func() { _ = sqlDB.Close() }()
+	require.Equal(t, 4, sqlDB.Stats().MaxOpenConnections)
+
+	require.Nil(t, db.AutoMigrate(&connUser{}))
+	require.Nil(t, db.Create(&connUser{ID: "usr-1", Name: "foo"}).Error)
+
+	err = db.Create(&connUser{ID: "usr-2", Name: "foo"}).Error
+	require.True(t, errors.Is(err, ErrDuplicatedKey))
+	require.Contains(t, err.Error(), "UNIQUE constraint failed")
+
+	err = db.Exec("INSERT INTO conn_users (id, name) VALUES (?, ?)", "usr-1", "bar").Error
+	require.True(t, errors.Is(err, ErrDuplicatedKey))
+}
+
+func TestNewConnMetrics(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	db, err := NewConn(ctx, &Config{
+		Driver:          DriverSQLite,
+		Database:        filepath.Join(t.TempDir(), "metrics.db"),
+		MaxIdleConn:     2,
+		MaxOpenConn:     4,
+		LogLevel:        int(SilentLevel),
+		ConnMaxLifetime: time.Minute,
+		Name:            "metrics-test",
+	})
+	require.Nil(t, err)
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+	defer func() { _ = sqlDB.Close() }()
+
+	require.Nil(t, db.AutoMigrate(&connUser{}))
+	require.Nil(t, db.Create(&connUser{ID: "usr-1", Name: "foo"}).Error)
+	require.NotNil(t, db.Create(&connUser{ID: "usr-1", Name: "bar"}).Error)
+	var user connUser
+	require.Equal(t, gorm.ErrRecordNotFound, db.Take(&user, "id = ?", "usr-2").Error)
+
+	// The gorm.ErrRecordNotFound is not an error.
+	require.Equal(t, float64(0), testutil.ToFloat64(queryErrorsCounter.WithLabelValues("metrics-test", "query", "conn_users")))
+	require.Equal(t, float64(1), testutil.ToFloat64(queryErrorsCounter.WithLabelValues("metrics-test", "create", "conn_users")))
+	for operation, count := range map[string]uint64{"create": 2, "query": 1} {
+		m := &dto.Metric{}
+		require.Nil(t, queryDurationHistogram.WithLabelValues("metrics-test", operation, "conn_users").(prometheus.Metric).Write(m))
+		require.Equal(t, count, m.GetHistogram().GetSampleCount(), operation)
+	}
+
+	require.GreaterOrEqual(t, testutil.CollectAndCount(dbStats), 5)
+}
+
+// openConnsOf returns the open_conns of db stats with name and host, -1 if not found.
+func openConnsOf(t *testing.T, name string, host string) float64 {
+	ch := make(chan prometheus.Metric, 64)
+	dbStats.Collect(ch)
+	close(ch)
+	for metric := range ch {
+		if metric.Desc() != dbStats.openConns {
+			continue
+		}
+		m := &dto.Metric{}
+		require.Nil(t, metric.Write(m))
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["name"] == name && labels["host"] == host {
+			return m.GetGauge().GetValue()
+		}
+	}
+	return -1
+}
+
+func TestCloseConn(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	database := filepath.Join(t.TempDir(), "close.db")
+	cfg := &Config{
+		Driver:          DriverSQLite,
+		Database:        database,
+		MaxIdleConn:     2,
+		MaxOpenConn:     4,
+		LogLevel:        int(SilentLevel),
+		ConnMaxLifetime: time.Minute,
+	}
+
+	// The connections with the same name and host are summed, the name defaults the database.
+	db1, err := NewConn(ctx, cfg)
+	require.Nil(t, err)
+	db2, err := NewConn(ctx, cfg)
+	require.Nil(t, err)
+	require.Nil(t, db1.Exec("SELECT 1").Error)
+	require.Nil(t, db2.Exec("SELECT 1").Error)
+	require.Equal(t, float64(2), openConnsOf(t, database, database))
+
+	require.Nil(t, CloseConn(db1))
+	require.Equal(t, float64(1), openConnsOf(t, database, database))
+	require.NotNil(t, db1.Exec("SELECT 1").Error)
+	require.Nil(t, CloseConn(db1))
+
+	require.Nil(t, CloseConn(db2))
+	require.Equal(t, float64(-1), openConnsOf(t, database, database))
+
+	// Not created by NewConn.
+	require.Nil(t, CloseConn(openTestSQLite(t, "other.db")))
+}
+
+func TestNewConnUnsupportedDriver(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	_, err := NewConn(ctx, &Config{Driver: "oracle", Hosts: "127.0.0.1:1521"})
+	require.NotNil(t, err)
+}
+
+func TestBuildDSN(t *testing.T) {
+	cfg := &Config{Users: "root", Password: "pass", Database: "data"}
+	require.Equal(t, "root:pass@tcp(127.0.0.1:3306)/data?charset=utf8mb4&parseTime=True&loc=Local",
+		buildDSN(DriverMySQL, cfg, "127.0.0.1:3306"))
+	require.Equal(t, "user='root' password='pass' host='127.0.0.1' port='5433' dbname='data'",
+		buildDSN(DriverPostgres, cfg, "127.0.0.1:5433"))
+	require.Equal(t, "user='root' password='pass' host='pg' port='5432' dbname='data'",
+		buildDSN(DriverPostgres, cfg, "pg"))
+
+	// The spaces and quotes in values are kept.
+	cfg.Password = `p a'ss\`
+	dsn := buildDSN(DriverPostgres, cfg, "pg")
+	require.Equal(t, `user='root' password='p a\'ss\\' host='pg' port='5432' dbname='data'`, dsn)
+	pgCfg, err := pgconn.ParseConfig(dsn)
+	require.Nil(t, err)
+	require.Equal(t, `p a'ss\`, pgCfg.Password)
+	require.Equal(t, "data", pgCfg.Database)
+}
+
+func TestConfigValidate(t *testing.T) {
+	validate := validator.New()
+
+	cfg := &Config{Hosts: "127.0.0.1:3306", Users: "root", Password: "pass", Database: "data",
+		MaxIdleConn: 1, MaxOpenConn: 1, LogLevel: 1, ConnMaxLifetime: time.Minute}
+	require.Nil(t, validate.Struct(cfg))
+	cfg.Driver = DriverPostgres
+	require.Nil(t, validate.Struct(cfg))
+	cfg.Driver = "oracle"
+	require.NotNil(t, validate.Struct(cfg))
+}
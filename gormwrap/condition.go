@@ -0,0 +1,404 @@
+package gormwrap
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The legacy operators used by Condition.Update and Condition.Operators.
+const (
+	CondEqual         = " = ? "
+	CondNotEqual      = " <> ? "
+	CondIn            = " IN ? "
+	CondLike          = " LIKE ? "
+	CondLikePlacehold = "%"
+
+	JointAnd   = " AND "
+	ReverseFmt = "%s DESC"
+)
+
+// Operator is the comparison operator of a condition.
+type Operator string
+
+const (
+	OpEqual        Operator = "="
+	OpNotEqual     Operator = "<>"
+	OpIn           Operator = "IN"
+	OpNotIn        Operator = "NOT IN"
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpBetween      Operator = "BETWEEN"
+	OpIsNull       Operator = "IS NULL"
+	OpIsNotNull    Operator = "IS NOT NULL"
+	// OpLike matches the value as a substring, the wildcards in value are escaped.
+	OpLike Operator = "LIKE"
+)
+
+// likeEscapeChar is used to escape the wildcards of LIKE patterns.
+const likeEscapeChar = "!"
+
+var (
+	legacyOperators = map[string]Operator{
+		CondEqual:    OpEqual,
+		CondNotEqual: OpNotEqual,
+		CondIn:       OpIn,
+		CondLike:     OpLike,
+	}
+
+	likeEscaper = strings.NewReplacer(likeEscapeChar, likeEscapeChar+likeEscapeChar, "%", likeEscapeChar+"%", "_", likeEscapeChar+"_")
+
+	columnNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+type condExpr struct {
+	column   string
+	operator Operator
+	value    interface{}
+}
+
+type condOrder struct {
+	column string
+	desc   bool
+}
+
+// Condition builds the WHERE, ORDER BY, OFFSET and LIMIT clauses of a query.
+//
+// The conditions are joined by AND in the order they are added, so the generated SQL is
+// deterministic. The column names are quoted and checked against the whitelist passed to
+// NewCondition, the error is added to the *gorm.DB by Build.
+//
+// Usage:
+//
+//	cond := gormwrap.NewCondition("id", "name", "status", "created").
+//		Where("status", gormwrap.OpIn, []string{"enabled", "disabled"}).
+//		Where("name", gormwrap.OpLike, name).
+//		Or(gormwrap.NewCondition().Where("id", gormwrap.OpEqual, id), ...).
+//		AddOrder("created", true)
+//	err := cond.Build(db.Model(&User{})).Find(&users).Error
+type Condition struct {
+	// Values and Operators are the conditions set by Update, they are joined by AND after the
+	// conditions of Where.
+	//
+	// Deprecated: Use Where instead, map is not ordered.
+	Values map[string]interface{}
+	// Deprecated: Use Where instead.
+	Operators map[string]string
+
+	Offset int
+	Limit  int
+	// Order and Reverse is the first column to order.
+	Order   string
+	Reverse bool
+
+	columns map[string]struct{}
+	exprs   []condExpr
+	updated []string
+	groups  [][]*Condition
+	orders  []condOrder
+}
+
+// NewCondition creates a Condition that only allows the columns.
+// Any column is allowed if no columns given.
+func NewCondition(columns ...string) *Condition {
+	c := &Condition{}
+	if len(columns) > 0 {
+		c.columns = make(map[string]struct{}, len(columns))
+		for _, column := range columns {
+			c.columns[column] = struct{}{}
+		}
+	}
+	return c
+}
+
+// Where adds a condition. The value is ignored for OpIsNull and OpIsNotNull, must be a slice
+// for OpIn and OpNotIn, and must be a slice of 2 elements for OpBetween. An empty slice of
+// OpIn matches nothing and of OpNotIn matches all.
+func (c *Condition) Where(column string, operator Operator, value interface{}) *Condition {
+	c.exprs = append(c.exprs, condExpr{column: column, operator: operator, value: value})
+	return c
+}
+
+// Or adds a group of conditions that are joined by OR, each condition in the group is wrapped
+// in parentheses. The OFFSET, LIMIT and ORDER of conditions in group are ignored.
+func (c *Condition) Or(conds ...*Condition) *Condition {
+	if len(conds) > 0 {
+		c.groups = append(c.groups, conds)
+	}
+	return c
+}
+
+// AddOrder appends a column to the ORDER BY clause.
+func (c *Condition) AddOrder(column string, desc bool) *Condition {
+	c.orders = append(c.orders, condOrder{column: column, desc: desc})
+	return c
+}
+
+// Update sets the condition of column into Values and Operators, the previous condition of the
+// column is replaced. The default operator is CondIn if the value is slice or array, otherwise
+// CondEqual. The conditions set by Update are built in the order of first update.
+func (c *Condition) Update(column string, value interface{}, operator ...string) {
+	if c.Values == nil {
+		c.Values = make(map[string]interface{})
+	}
+	if c.Operators == nil {
+		c.Operators = make(map[string]string)
+	}
+	if _, ok := c.Values[column]; !ok {
+		c.updated = append(c.updated, column)
+	}
+
+	c.Values[column] = value
+	if len(operator) > 0 {
+		c.Operators[column] = operator[0]
+	} else if isSliceValue(value) {
+		c.Operators[column] = CondIn
+	} else {
+		c.Operators[column] = CondEqual
+	}
+}
+
+func (c *Condition) UpdateLimit(offset, limit int) {
+	c.Offset = offset
+	c.Limit = limit
+}
+
+func (c *Condition) UpdateOrder(order string, reverse bool) {
+	c.Order = order
+	c.Reverse = reverse
+}
+
+// Build applies the conditions to tx. Errors are added to tx by tx.AddError.
+func (c *Condition) Build(tx *gorm.DB) *gorm.DB {
+	exprs, err := c.expressions()
+	if err != nil {
+		_ = tx.AddError(err)
+		return tx
+	}
+	if len(exprs) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: exprs})
+	}
+
+	if c.Limit > 0 {
+		tx = tx.Offset(c.Offset).Limit(c.Limit)
+	}
+
+	orders := c.orders
+	if c.Order != "" {
+		orders = append([]condOrder{{column: c.Order, desc: c.Reverse}}, orders...)
+	}
+	if len(orders) > 0 {
+		columns := make([]clause.OrderByColumn, 0, len(orders))
+		for _, o := range orders {
+			if err = c.checkColumn(o.column); err != nil {
+				_ = tx.AddError(err)
+				return tx
+			}
+			columns = append(columns, clause.OrderByColumn{Column: clause.Column{Name: o.column}, Desc: o.desc})
+		}
+		tx = tx.Clauses(clause.OrderBy{Columns: columns})
+	}
+	return tx
+}
+
+// expressions returns the where expressions in order.
+func (c *Condition) expressions() ([]clause.Expression, error) {
+	exprs := make([]clause.Expression, 0, len(c.exprs)+len(c.Values)+len(c.groups))
+	for _, e := range c.exprs {
+		expr, err := c.expression(e)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	for _, column := range c.legacyColumns() {
+		op := OpEqual
+		if operator, ok := c.Operators[column]; ok {
+			op = legacyOperator(operator)
+		}
+		expr, err := c.expression(condExpr{column: column, operator: op, value: c.Values[column]})
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	for _, group := range c.groups {
+		ors := make([]clause.Expression, 0, len(group))
+		for _, g := range group {
+			sub, err := g.expressions()
+			if err != nil {
+				return nil, err
+			}
+			if err = c.checkColumns(g); err != nil {
+				return nil, err
+			}
+			if len(sub) > 0 {
+				ors = append(ors, clause.And(sub...))
+			}
+		}
+		switch len(ors) {
+		case 0:
+		case 1:
+			// A single clause.Or is joined to the previous expressions by OR.
+			exprs = append(exprs, ors[0])
+		default:
+			exprs = append(exprs, clause.Or(ors...))
+		}
+	}
+	return exprs, nil
+}
+
+// legacyColumns returns the columns of Values, the columns set by Update are in the order of
+// first update, and the others set to the map directly are sorted at last.
+func (c *Condition) legacyColumns() []string {
+	columns := make([]string, 0, len(c.Values))
+	seen := make(map[string]struct{}, len(c.updated))
+	for _, column := range c.updated {
+		if _, ok := c.Values[column]; ok {
+			columns = append(columns, column)
+			seen[column] = struct{}{}
+		}
+	}
+	rest := make([]string, 0, len(c.Values)-len(columns))
+	for column := range c.Values {
+		if _, ok := seen[column]; !ok {
+			rest = append(rest, column)
+		}
+	}
+	sort.Strings(rest)
+	return append(columns, rest...)
+}
+
+func (c *Condition) expression(e condExpr) (clause.Expression, error) {
+	if err := c.checkColumn(e.column); err != nil {
+		return nil, err
+	}
+	column := clause.Column{Name: e.column}
+	value := e.value
+
+	switch e.operator {
+	case OpEqual:
+		return clause.Eq{Column: column, Value: value}, nil
+	case OpNotEqual:
+		return clause.Neq{Column: column, Value: value}, nil
+	case OpGreater:
+		return clause.Gt{Column: column, Value: value}, nil
+	case OpGreaterEqual:
+		return clause.Gte{Column: column, Value: value}, nil
+	case OpLess:
+		return clause.Lt{Column: column, Value: value}, nil
+	case OpLessEqual:
+		return clause.Lte{Column: column, Value: value}, nil
+	case OpIsNull:
+		return clause.Expr{SQL: "? IS NULL", Vars: []interface{}{column}}, nil
+	case OpIsNotNull:
+		return clause.Expr{SQL: "? IS NOT NULL", Vars: []interface{}{column}}, nil
+	case OpLike:
+		pattern := CondLikePlacehold + likeEscaper.Replace(fmt.Sprint(value)) + CondLikePlacehold
+		return clause.Expr{SQL: "? LIKE ? ESCAPE '" + likeEscapeChar + "'", Vars: []interface{}{column, pattern}}, nil
+	case OpIn, OpNotIn:
+		values, err := sliceValues(e.column, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			// gorm renders an empty NOT IN as IS NOT NULL that excludes the NULL values.
+			if e.operator == OpNotIn {
+				return clause.Expr{SQL: "1=1"}, nil
+			}
+			return clause.Expr{SQL: "1=0"}, nil
+		}
+		in := clause.IN{Column: column, Values: values}
+		if e.operator == OpNotIn {
+			return clause.Not(in), nil
+		}
+		return in, nil
+	case OpBetween:
+		values, err := sliceValues(e.column, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("gormwrap: BETWEEN of column %q requires 2 values, got %d", e.column, len(values))
+		}
+		return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{column, values[0], values[1]}}, nil
+	default:
+		return nil, fmt.Errorf("gormwrap: unsupported operator %q of column %q", e.operator, e.column)
+	}
+}
+
+// checkColumns checks the columns of sub condition against the whitelist of c.
+func (c *Condition) checkColumns(sub *Condition) error {
+	for _, e := range sub.exprs {
+		if err := c.checkColumn(e.column); err != nil {
+			return err
+		}
+	}
+	for column := range sub.Values {
+		if err := c.checkColumn(column); err != nil {
+			return err
+		}
+	}
+	for _, group := range sub.groups {
+		for _, g := range group {
+			if err := c.checkColumns(g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Condition) checkColumn(column string) error {
+	if !columnNameRegexp.MatchString(column) {
+		return fmt.Errorf("gormwrap: invalid column name %q", column)
+	}
+	if c.columns == nil {
+		return nil
+	}
+	if _, ok := c.columns[column]; !ok {
+		return fmt.Errorf("gormwrap: column %q is not allowed", column)
+	}
+	return nil
+}
+
+// legacyOperator converts the operator such as CondEqual to Operator.
+func legacyOperator(operator string) Operator {
+	if op, ok := legacyOperators[operator]; ok {
+		return op
+	}
+	return Operator(strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(operator), "?"))))
+}
+
+// isSliceValue reports whether the value is a slice or array, except []byte.
+func isSliceValue(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	if _, ok := value.([]byte); ok {
+		return false
+	}
+	kind := reflect.TypeOf(value).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+func sliceValues(column string, value interface{}) ([]interface{}, error) {
+	if !isSliceValue(value) {
+		return nil, fmt.Errorf("gormwrap: value of column %q must be a slice, got %T", column, value)
+	}
+	rv := reflect.ValueOf(value)
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values, nil
+}
@@ -0,0 +1,158 @@
+package gormwrap
+
+import (
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type conditionUser struct {
+	ID      int64
+	Name    string
+	Status  string
+	Created int64
+}
+
+// newDryRunMySQL returns a *gorm.DB that only generates SQL without connecting to mysql.
+func newDryRunMySQL(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:password@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               &Logger{Level: SilentLevel, Output: glog.NewDefault()},
+	})
+	require.Nil(t, err)
+	return db
+}
+
+func buildSQL(db *gorm.DB, cond *Condition) (string, []interface{}, error) {
+	var users []conditionUser
+	stmt := cond.Build(db.Model(&conditionUser{})).Find(&users)
+	return stmt.Statement.SQL.String(), stmt.Statement.Vars, stmt.Error
+}
+
+func TestConditionBuild(t *testing.T) {
+	db := newDryRunMySQL(t)
+
+	cond := NewCondition("id", "name", "status", "created").
+		Where("status", OpIn, []string{"enabled", "disabled"}).
+		Where("name", OpLike, "50%_off!").
+		Where("id", OpNotIn, []int64{1, 2}).
+		Where("created", OpBetween, []int64{100, 200}).
+		Where("name", OpIsNotNull, nil).
+		Where("id", OpGreaterEqual, 10).
+		Or(
+			NewCondition().Where("status", OpEqual, "deleted"),
+			NewCondition().Where("created", OpLess, 50).Where("name", OpIsNull, nil),
+		).
+		AddOrder("created", true).
+		AddOrder("id", false)
+	cond.UpdateLimit(20, 10)
+
+	sql, vars, err := buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users` WHERE `status` IN (?,?) AND `name` LIKE ? ESCAPE '!' "+
+		"AND `id` NOT IN (?,?) AND (`created` BETWEEN ? AND ?) AND `name` IS NOT NULL AND `id` >= ? "+
+		"AND (`status` = ? OR (`created` < ? AND `name` IS NULL)) "+
+		"ORDER BY `created` DESC,`id` LIMIT 10 OFFSET 20", sql)
+	require.Equal(t, []interface{}{
+		"enabled", "disabled", "%50!%!_off!!%", int64(1), int64(2), int64(100), int64(200), 10, "deleted", 50,
+	}, vars)
+
+	// Deterministic.
+	for i := 0; i < 10; i++ {
+		sql2, _, err := buildSQL(db, cond)
+		require.Nil(t, err)
+		require.Equal(t, sql, sql2)
+	}
+}
+
+func TestConditionSingleOr(t *testing.T) {
+	db := newDryRunMySQL(t)
+
+	// The single condition in Or group is still joined by AND.
+	cond := NewCondition().
+		Where("status", OpEqual, "enabled").
+		Or(NewCondition().Where("id", OpLess, 10).Where("name", OpEqual, "foo"))
+	sql, vars, err := buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users` WHERE `status` = ? AND (`id` < ? AND `name` = ?)", sql)
+	require.Equal(t, []interface{}{"enabled", 10, "foo"}, vars)
+
+	cond = NewCondition().
+		Where("status", OpEqual, "enabled").
+		Or(NewCondition().Where("id", OpLess, 10))
+	sql, _, err = buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users` WHERE `status` = ? AND `id` < ?", sql)
+}
+
+func TestConditionEmptyIn(t *testing.T) {
+	db := newDryRunMySQL(t)
+
+	cond := NewCondition().
+		Where("status", OpNotIn, []string{}).
+		Where("id", OpIn, []int64{})
+	sql, vars, err := buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users` WHERE 1=1 AND 1=0", sql)
+	require.Len(t, vars, 0)
+}
+
+func TestConditionLegacy(t *testing.T) {
+	db := newDryRunMySQL(t)
+
+	cond := &Condition{
+		Values:    map[string]interface{}{},
+		Operators: map[string]string{},
+	}
+	cond.Update("status", []string{"enabled"})
+	cond.Update("name", "foo", CondLike)
+	cond.Update("id", 1)
+	cond.Update("id", 2, CondNotEqual)
+	cond.Values["created"] = 100
+	cond.Operators["created"] = " > ? "
+	cond.Values["a_col"] = "x"
+	cond.UpdateOrder("created", true)
+	require.Equal(t, map[string]interface{}{"status": []string{"enabled"}, "name": "foo", "id": 2, "created": 100, "a_col": "x"}, cond.Values)
+	require.Equal(t, map[string]string{"status": CondIn, "name": CondLike, "id": CondNotEqual, "created": " > ? "}, cond.Operators)
+
+	sql, vars, err := buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users` WHERE `status` = ? AND `name` LIKE ? ESCAPE '!' "+
+		"AND `id` <> ? AND `a_col` = ? AND `created` > ? ORDER BY `created` DESC", sql)
+	require.Equal(t, []interface{}{"enabled", "%foo%", 2, "x", 100}, vars)
+
+	// The maps are created by Update if nil.
+	cond = NewCondition("id")
+	cond.Update("id", []int64{1, 2})
+	require.Equal(t, map[string]interface{}{"id": []int64{1, 2}}, cond.Values)
+	require.Equal(t, map[string]string{"id": CondIn}, cond.Operators)
+	delete(cond.Values, "id")
+	sql, _, err = buildSQL(db, cond)
+	require.Nil(t, err)
+	require.Equal(t, "SELECT * FROM `condition_users`", sql)
+}
+
+func TestConditionErrors(t *testing.T) {
+	db := newDryRunMySQL(t)
+
+	cases := []*Condition{
+		NewCondition("id").Where("name", OpEqual, "foo"),
+		NewCondition("id").AddOrder("name", false),
+		NewCondition("id").Or(NewCondition().Where("name", OpEqual, "foo")),
+		NewCondition().Where("id; DROP TABLE users", OpEqual, 1),
+		NewCondition().Where("id", OpIn, 1),
+		NewCondition().Where("id", OpBetween, []int{1}),
+		NewCondition().Where("id", Operator("REGEXP"), "x"),
+	}
+	for _, cond := range cases {
+		_, _, err := buildSQL(db, cond)
+		require.NotNil(t, err)
+	}
+}
@@ -0,0 +1,94 @@
+package gormwrap
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ prometheus.Collector = (*dbStatsCollector)(nil)
+)
+
+type dbStatsKey struct {
+	name string
+	host string
+}
+
+// dbStatsCollector implements prometheus.Collector to collects the sql.DBStats of all
+// connections created by NewConn, including the replicas. The stats of connections with the
+// same name and host are summed.
+type dbStatsCollector struct {
+	mu  sync.Mutex
+	dbs map[*sql.DB]dbStatsKey
+
+	openConns    *prometheus.Desc
+	inUseConns   *prometheus.Desc
+	idleConns    *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newDBStatsCollector() *dbStatsCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricNamespace, "pool", name), help, []string{"name", "host"}, nil)
+	}
+	return &dbStatsCollector{
+		dbs:          make(map[*sql.DB]dbStatsKey),
+		openConns:    desc("open_conns", "Number of established connections both in use and idle."),
+		inUseConns:   desc("in_use_conns", "Number of connections currently in use."),
+		idleConns:    desc("idle_conns", "Number of idle connections."),
+		waitCount:    desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration: desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+	}
+}
+
+// add tracks the db by name and host until it's removed.
+func (c *dbStatsCollector) add(name string, host string, db *sql.DB) {
+	c.mu.Lock()
+	c.dbs[db] = dbStatsKey{name: name, host: host}
+	c.mu.Unlock()
+}
+
+// remove stops tracking the db.
+func (c *dbStatsCollector) remove(db *sql.DB) {
+	c.mu.Lock()
+	delete(c.dbs, db)
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConns
+	ch <- c.inUseConns
+	ch <- c.idleConns
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	stats := make(map[dbStatsKey]sql.DBStats, len(c.dbs))
+	for db, key := range c.dbs {
+		s := db.Stats()
+		if sum, ok := stats[key]; ok {
+			s.OpenConnections += sum.OpenConnections
+			s.InUse += sum.InUse
+			s.Idle += sum.Idle
+			s.WaitCount += sum.WaitCount
+			s.WaitDuration += sum.WaitDuration
+		}
+		stats[key] = s
+	}
+	c.mu.Unlock()
+
+	for key, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.openConns, prometheus.GaugeValue, float64(s.OpenConnections), key.name, key.host)
+		ch <- prometheus.MustNewConstMetric(c.inUseConns, prometheus.GaugeValue, float64(s.InUse), key.name, key.host)
+		ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.Idle), key.name, key.host)
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount), key.name, key.host)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), key.name, key.host)
+	}
+}
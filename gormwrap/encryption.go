@@ -0,0 +1,215 @@
+package gormwrap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// The format of encrypted value is "enc:v1:<key id>:<encrypted data key>:<ciphertext>", the
+// data key and ciphertext are encoded by base64 with the nonce of AES-GCM as prefix.
+const (
+	encryptedPrefix    = "enc:v1:"
+	encryptedSeparator = ":"
+	dataKeySize        = 32
+)
+
+// ErrNoKeyProvider is returned when the encrypted columns are used before SetKeyProvider.
+var ErrNoKeyProvider = errors.New("gormwrap: key provider of encrypted columns is not set")
+
+var keyProvider atomic.Value
+
+type keyProviderHolder struct {
+	p KeyProvider
+}
+
+// SetKeyProvider sets the KeyProvider used by EncryptedString and EncryptedBytes.
+// It must be called at startup before using the encrypted columns.
+func SetKeyProvider(p KeyProvider) {
+	keyProvider.Store(&keyProviderHolder{p: p})
+}
+
+func getKeyProvider() (KeyProvider, error) {
+	h, ok := keyProvider.Load().(*keyProviderHolder)
+	if !ok || h.p == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return h.p, nil
+}
+
+// EncryptedString is a string column encrypted by AES-GCM envelope encryption: each value is
+// encrypted by a random data key, and the data key is encrypted by the current key of
+// KeyProvider. It's encrypted on write and decrypted on read transparently.
+//
+// The empty string is stored as is, and the values not encrypted are read as plaintext, so the
+// existing plaintext column can be migrated by reading and writing the rows again. Rewriting
+// the rows also re-encrypts them by the current key after key rotation.
+//
+// NOTICE: The encrypted columns cannot be used in WHERE conditions.
+type EncryptedString string
+
+// GormDataType implements schema.GormDataTypeInterface.
+func (EncryptedString) GormDataType() string {
+	return "text"
+}
+
+// Value implements the driver.Valuer interface.
+func (s EncryptedString) Value() (driver.Value, error) {
+	return encryptValue([]byte(s))
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *EncryptedString) Scan(value interface{}) error {
+	plaintext, err := decryptValue(value)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+// EncryptedBytes is the []byte version of EncryptedString.
+type EncryptedBytes []byte
+
+// GormDataType implements schema.GormDataTypeInterface.
+func (EncryptedBytes) GormDataType() string {
+	return "text"
+}
+
+// Value implements the driver.Valuer interface.
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	return encryptValue(b)
+}
+
+// Scan implements the sql.Scanner interface.
+func (b *EncryptedBytes) Scan(value interface{}) error {
+	plaintext, err := decryptValue(value)
+	if err != nil {
+		return err
+	}
+	if plaintext == nil {
+		*b = nil
+	} else {
+		*b = append((*b)[:0], plaintext...)
+	}
+	return nil
+}
+
+func encryptValue(plaintext []byte) (driver.Value, error) {
+	if len(plaintext) == 0 {
+		return "", nil
+	}
+	p, err := getKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	id, key, err := p.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := sealGCM(key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := sealGCM(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedPrefix + id + encryptedSeparator +
+		base64.RawStdEncoding.EncodeToString(encryptedKey) + encryptedSeparator +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+func decryptValue(value interface{}) ([]byte, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return nil, fmt.Errorf("gormwrap: unsupported type %T of encrypted column", value)
+	}
+	if !strings.HasPrefix(s, encryptedPrefix) {
+		// Not encrypted yet.
+		return []byte(s), nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(s, encryptedPrefix), encryptedSeparator)
+	if len(parts) != 3 {
+		return nil, errors.New("gormwrap: invalid format of encrypted column")
+	}
+	p, err := getKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.Key(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("gormwrap: decode data key: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("gormwrap: decode ciphertext: %w", err)
+	}
+	dataKey, err := openGCM(key, encryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("gormwrap: decrypt data key by key %q: %w", parts[0], err)
+	}
+	plaintext, err := openGCM(dataKey, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("gormwrap: decrypt ciphertext: %w", err)
+	}
+	return plaintext, nil
+}
+
+// sealGCM encrypts plaintext and returns the nonce with ciphertext.
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openGCM decrypts the data returned by sealGCM.
+func openGCM(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,94 @@
+package gormwrap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type encryptedDatasource struct {
+	ID        string `gorm:"primaryKey"`
+	URL       EncryptedString
+	SecretKey EncryptedBytes
+}
+
+func newTestKeyProvider(t *testing.T, current string) KeyProvider {
+	p, err := NewStaticKeyProvider(current, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	})
+	require.Nil(t, err)
+	return p
+}
+
+func TestEncryptedColumns(t *testing.T) {
+	db := openTestSQLite(t, "encryption.db")
+	require.Nil(t, db.AutoMigrate(&encryptedDatasource{}))
+	defer SetKeyProvider(nil)
+
+	SetKeyProvider(newTestKeyProvider(t, "k1"))
+	url := `{"user":"root","password":"p@ss:word"}`
+	require.Nil(t, db.Create(&encryptedDatasource{ID: "som-1", URL: EncryptedString(url), SecretKey: []byte("secret")}).Error)
+
+	var raw string
+	require.Nil(t, db.Raw("SELECT url FROM encrypted_datasources WHERE id = ?", "som-1").Scan(&raw).Error)
+	require.True(t, strings.HasPrefix(raw, "enc:v1:k1:"))
+	require.NotContains(t, raw, "password")
+
+	var ds encryptedDatasource
+	require.Nil(t, db.Take(&ds, "id = ?", "som-1").Error)
+	require.Equal(t, url, string(ds.URL))
+	require.Equal(t, []byte("secret"), []byte(ds.SecretKey))
+
+	// Rotation: the old rows are still readable, new writes use the current key.
+	SetKeyProvider(newTestKeyProvider(t, "k2"))
+	require.Nil(t, db.Take(&ds, "id = ?", "som-1").Error)
+	require.Equal(t, url, string(ds.URL))
+	require.Nil(t, db.Save(&ds).Error)
+	require.Nil(t, db.Raw("SELECT url FROM encrypted_datasources WHERE id = ?", "som-1").Scan(&raw).Error)
+	require.True(t, strings.HasPrefix(raw, "enc:v1:k2:"))
+
+	// Plaintext and empty values.
+	require.Nil(t, db.Exec("INSERT INTO encrypted_datasources (id, url, secret_key) VALUES (?, ?, ?)", "som-2", "plain", "").Error)
+	var plain encryptedDatasource
+	require.Nil(t, db.Take(&plain, "id = ?", "som-2").Error)
+	require.Equal(t, "plain", string(plain.URL))
+	require.Empty(t, plain.SecretKey)
+
+	// Unknown key.
+	p, err := NewStaticKeyProvider("k3", map[string][]byte{"k3": bytes.Repeat([]byte{3}, 32)})
+	require.Nil(t, err)
+	SetKeyProvider(p)
+	require.NotNil(t, db.Take(&encryptedDatasource{}, "id = ?", "som-1").Error)
+
+	SetKeyProvider(nil)
+	require.Equal(t, ErrNoKeyProvider, ds.URL.Scan(raw))
+}
+
+func TestKeyProvider(t *testing.T) {
+	_, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("short")})
+	require.NotNil(t, err)
+	_, err = NewStaticKeyProvider("k2", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NotNil(t, err)
+	_, err = NewStaticKeyProvider("k:1", map[string][]byte{"k:1": bytes.Repeat([]byte{1}, 32)})
+	require.NotNil(t, err)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	filename := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"current": "k1", "keys": {"k1": "` + base64.StdEncoding.EncodeToString(key) + `"}}`
+	require.Nil(t, ioutil.WriteFile(filename, []byte(content), 0600))
+
+	p, err := NewFileKeyProvider(filename)
+	require.Nil(t, err)
+	id, got, err := p.CurrentKey()
+	require.Nil(t, err)
+	require.Equal(t, "k1", id)
+	require.Equal(t, key, got)
+	_, err = p.Key("k2")
+	require.NotNil(t, err)
+}
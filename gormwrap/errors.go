@@ -0,0 +1,156 @@
+package gormwrap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+const errorTranslatorName = "gormwrap:error_translator"
+
+// The common errors translated from the errors of drivers, check them by errors.Is.
+// The original error of driver can still be got by errors.As.
+var (
+	ErrDuplicatedKey       = errors.New("gormwrap: duplicated key")
+	ErrForeignKeyViolation = errors.New("gormwrap: foreign key violation")
+	ErrDeadlock            = errors.New("gormwrap: deadlock")
+	ErrLockWaitTimeout     = errors.New("gormwrap: lock wait timeout")
+)
+
+// The error codes of drivers.
+const (
+	mysqlDuplicateEntry   = 1062
+	mysqlLockWaitTimeout  = 1205
+	mysqlDeadlock         = 1213
+	mysqlRowIsReferenced  = 1451
+	mysqlNoReferencedRow  = 1452
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgDeadlockDetected    = "40P01"
+	pgLockNotAvailable    = "55P03"
+)
+
+// translatedError is the error of driver with a common error.
+type translatedError struct {
+	sentinel error
+	err      error
+}
+
+func (e *translatedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *translatedError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *translatedError) Unwrap() error {
+	return e.err
+}
+
+// TranslateError translates the error of mysql, postgres and sqlite drivers to the common
+// errors such as ErrDuplicatedKey. Returns err itself if no common error matched.
+//
+// The errors of queries are translated by the conn created by NewConn, this is for the errors
+// returned from elsewhere such as the commit of transaction.
+func TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var translated *translatedError
+	if errors.As(err, &translated) {
+		return err
+	}
+	if sentinel := sentinelOf(err); sentinel != nil {
+		return &translatedError{sentinel: sentinel, err: err}
+	}
+	return err
+}
+
+func sentinelOf(err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case mysqlDuplicateEntry:
+			return ErrDuplicatedKey
+		case mysqlRowIsReferenced, mysqlNoReferencedRow:
+			return ErrForeignKeyViolation
+		case mysqlDeadlock:
+			return ErrDeadlock
+		case mysqlLockWaitTimeout:
+			return ErrLockWaitTimeout
+		}
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return ErrDuplicatedKey
+		case pgForeignKeyViolation:
+			return ErrForeignKeyViolation
+		case pgDeadlockDetected:
+			return ErrDeadlock
+		case pgLockNotAvailable:
+			return ErrLockWaitTimeout
+		}
+		return nil
+	}
+
+	// The sqlite driver requires cgo, so matches its messages instead of error codes.
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "UNIQUE constraint failed"):
+		return ErrDuplicatedKey
+	case strings.HasPrefix(msg, "FOREIGN KEY constraint failed"):
+		return ErrForeignKeyViolation
+	case strings.HasPrefix(msg, "database is locked"), strings.HasPrefix(msg, "database table is locked"):
+		return ErrLockWaitTimeout
+	}
+	return nil
+}
+
+// errorTranslatorPlugin implements gorm.Plugin to translate the errors of queries.
+type errorTranslatorPlugin struct{}
+
+func newErrorTranslatorPlugin() gorm.Plugin {
+	return &errorTranslatorPlugin{}
+}
+
+// Name implements gorm.Plugin.
+func (p *errorTranslatorPlugin) Name() string {
+	return errorTranslatorName
+}
+
+// Initialize implements gorm.Plugin.
+func (p *errorTranslatorPlugin) Initialize(db *gorm.DB) (err error) {
+	if err = db.Callback().Create().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	if err = db.Callback().Query().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	if err = db.Callback().Update().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	if err = db.Callback().Row().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	if err = db.Callback().Raw().After("*").Register(errorTranslatorName, p.translate); err != nil {
+		return
+	}
+	return
+}
+
+func (p *errorTranslatorPlugin) translate(db *gorm.DB) {
+	if db.Error != nil {
+		db.Error = TranslateError(db.Error)
+	}
+}
@@ -0,0 +1,44 @@
+package gormwrap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateError(t *testing.T) {
+	cases := []struct {
+		err      error
+		sentinel error
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, ErrDuplicatedKey},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, ErrDeadlock},
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, ErrLockWaitTimeout},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, ErrForeignKeyViolation},
+		{&pgconn.PgError{Code: "23505"}, ErrDuplicatedKey},
+		{&pgconn.PgError{Code: "40P01"}, ErrDeadlock},
+		{&pgconn.PgError{Code: "55P03"}, ErrLockWaitTimeout},
+		{fmt.Errorf("exec: %w", &pgconn.PgError{Code: "23503"}), ErrForeignKeyViolation},
+		{errors.New("UNIQUE constraint failed: users.id"), ErrDuplicatedKey},
+		{errors.New("database is locked"), ErrLockWaitTimeout},
+	}
+	for _, c := range cases {
+		err := TranslateError(c.err)
+		require.True(t, errors.Is(err, c.sentinel), c.err.Error())
+		require.True(t, errors.Is(err, c.err))
+		require.Equal(t, c.err.Error(), err.Error())
+		require.Equal(t, err, TranslateError(err))
+	}
+
+	myErr := &mysql.MySQLError{Number: 1064, Message: "syntax error"}
+	require.Equal(t, error(myErr), TranslateError(myErr))
+	require.Nil(t, TranslateError(nil))
+
+	var target *mysql.MySQLError
+	require.True(t, errors.As(TranslateError(&mysql.MySQLError{Number: 1062}), &target))
+	require.Equal(t, uint16(1062), target.Number)
+}
@@ -0,0 +1,322 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/gorm"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+// The max number of fingerprints remembered by the rate limiter of explainPlugin.
+const maxExplainFingerprints = 4096
+
+var (
+	fingerprintStringRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	fingerprintParamRegexp  = regexp.MustCompile(`\$\d+`)
+	fingerprintNumberRegexp = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	fingerprintListRegexp   = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	fingerprintSpaceRegexp  = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeSQL returns the sql with the literals and placeholders replaced by "?", the list of them
+// replaced by "(?+)" and the whitespaces collapsed, so the statements only differ by values are
+// the same.
+func NormalizeSQL(sql string) string {
+	sql = fingerprintStringRegexp.ReplaceAllString(sql, "?")
+	sql = fingerprintParamRegexp.ReplaceAllString(sql, "?")
+	sql = fingerprintNumberRegexp.ReplaceAllString(sql, "?")
+	sql = fingerprintSpaceRegexp.ReplaceAllString(sql, " ")
+	sql = fingerprintListRegexp.ReplaceAllString(sql, "(?+)")
+	return strings.ToLower(strings.TrimSpace(sql))
+}
+
+// Fingerprint returns the hash in hex of NormalizeSQL(sql).
+func Fingerprint(sql string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(NormalizeSQL(sql)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// ExplainOption used to change the behavior of explaining slow queries.
+type ExplainOption func(o *explainOptions)
+
+type explainOptions struct {
+	sampleRate float64
+	interval   time.Duration
+	timeout    time.Duration
+	pools      map[*sql.DB]*sql.DB
+}
+
+// WithExplainSampleRate sets the ratio of slow queries to explain, in range (0, 1]. Defaults 1.
+func WithExplainSampleRate(rate float64) ExplainOption {
+	return func(o *explainOptions) {
+		o.sampleRate = rate
+	}
+}
+
+// WithExplainInterval sets the min interval to explain the queries with same fingerprint.
+// Defaults 1m.
+func WithExplainInterval(d time.Duration) ExplainOption {
+	return func(o *explainOptions) {
+		o.interval = d
+	}
+}
+
+// WithExplainTimeout sets the timeout of the EXPLAIN statement. Defaults 5s.
+func WithExplainTimeout(d time.Duration) ExplainOption {
+	return func(o *explainOptions) {
+		o.timeout = d
+	}
+}
+
+// WithExplainDB sets the separate connection pools to run EXPLAIN by the pool of service. The
+// queries are explained on the separate pool of the primary or replica that they ran on, the ones
+// of the pools without a separate pool are not explained. A pool with MaxOpenConns 1 is enough.
+func WithExplainDB(pools map[*sql.DB]*sql.DB) ExplainOption {
+	return func(o *explainOptions) {
+		o.pools = pools
+	}
+}
+
+const (
+	explainName     = "gormwrap:explain"
+	explainStartKey = "gormwrap:explain_start"
+)
+
+// explainPlugin implements gorm.Plugin to run EXPLAIN of the slow SELECTs and log the plan.
+//
+// The EXPLAIN runs in background with the SQL and bound parameters of the query, on the separate
+// pool of the replica or primary that the query ran on, so it never takes a connection from the
+// pool of service. There is at most one EXPLAIN at a time and the slow queries are skipped while
+// it's running. The queries in transactions are explained on the primary since the transaction
+// may be finished.
+type explainPlugin struct {
+	lp        *glog.Logger
+	threshold time.Duration
+	options   explainOptions
+	driver    string
+	primary   *sql.DB
+	running   chan struct{}
+
+	mu   sync.Mutex
+	last map[string]time.Time // last explained time by fingerprint
+}
+
+// NewExplainPlugin returns the gorm plugin that explains the SELECTs slower than slowThreshold and
+// logs the plan by output, the glog.Logger in context is used first. The queries are explained at
+// most once every interval per fingerprint, see ExplainOption. The separate pools must be set by
+// WithExplainDB, NewConn does it if the Config.ExplainSampleRate is set.
+//
+// Usage: err := db.Use(gormwrap.NewExplainPlugin(lp, time.Second, gormwrap.WithExplainDB(pools), gormwrap.WithExplainSampleRate(0.1)))
+func NewExplainPlugin(output *glog.Logger, slowThreshold time.Duration, opts ...ExplainOption) gorm.Plugin {
+	return newExplainPlugin(output, slowThreshold, opts...)
+}
+
+func newExplainPlugin(output *glog.Logger, slowThreshold time.Duration, opts ...ExplainOption) *explainPlugin {
+	o := explainOptions{
+		sampleRate: 1,
+		interval:   time.Minute,
+		timeout:    time.Second * 5,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &explainPlugin{
+		lp:        output,
+		threshold: slowThreshold,
+		options:   o,
+		running:   make(chan struct{}, 1),
+		last:      make(map[string]time.Time),
+	}
+}
+
+// Name implements gorm.Plugin.
+func (e *explainPlugin) Name() string {
+	return explainName
+}
+
+// Initialize implements gorm.Plugin.
+func (e *explainPlugin) Initialize(db *gorm.DB) (err error) {
+	e.driver = db.Dialector.Name()
+	e.primary, _ = db.ConnPool.(*sql.DB)
+	if err = db.Callback().Query().Before("*").Register(explainName+":before", e.before); err != nil {
+		return
+	}
+	if err = db.Callback().Query().After("*").Register(explainName+":after", e.after); err != nil {
+		return
+	}
+	if err = db.Callback().Row().Before("*").Register(explainName+":before", e.before); err != nil {
+		return
+	}
+	if err = db.Callback().Row().After("*").Register(explainName+":after", e.after); err != nil {
+		return
+	}
+	return
+}
+
+func (e *explainPlugin) before(db *gorm.DB) {
+	db.InstanceSet(explainStartKey, time.Now())
+}
+
+func (e *explainPlugin) after(db *gorm.DB) {
+	if db.Error != nil || db.DryRun {
+		return
+	}
+	v, ok := db.InstanceGet(explainStartKey)
+	if !ok {
+		return
+	}
+	start, ok := v.(time.Time)
+	if !ok || time.Since(start) <= e.threshold {
+		return
+	}
+	query := db.Statement.SQL.String()
+	if !isSelect(query) {
+		return
+	}
+	pool := e.explainDB(db.Statement.ConnPool)
+	if pool == nil {
+		return
+	}
+	// Takes the slot first, so the skipped query is not rate limited.
+	select {
+	case e.running <- struct{}{}:
+	default:
+		return
+	}
+	fingerprint := Fingerprint(query)
+	if !e.allow(fingerprint, time.Now()) {
+		<-e.running
+		return
+	}
+
+	vars := make([]interface{}, len(db.Statement.Vars))
+	copy(vars, db.Statement.Vars)
+
+	ctx := db.Statement.Context
+	l := glog.FromContext(ctx)
+	if l == nil {
+		l = e.lp
+	}
+	e.explainAsync(l, pool, fingerprint, gtrace.IdFromContext(ctx), query, vars, db.RowsAffected)
+}
+
+// explainDB returns the separate pool to explain the queries ran on pool, nil if not found.
+func (e *explainPlugin) explainDB(pool gorm.ConnPool) *sql.DB {
+	var db *sql.DB
+	switch p := pool.(type) {
+	case gorm.TxCommitter:
+		db = e.primary
+	case *replicaConnPool:
+		db = p.DB
+	case *sql.DB:
+		db = p
+	}
+	if db == nil {
+		return nil
+	}
+	return e.options.pools[db]
+}
+
+// allow reports whether the query of fingerprint should be explained now.
+func (e *explainPlugin) allow(fingerprint string, now time.Time) bool {
+	if e.options.sampleRate < 1 && rand.Float64() >= e.options.sampleRate {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if last, ok := e.last[fingerprint]; ok && now.Sub(last) < e.options.interval {
+		return false
+	}
+	if len(e.last) >= maxExplainFingerprints {
+		for k, last := range e.last {
+			if now.Sub(last) >= e.options.interval {
+				delete(e.last, k)
+			}
+		}
+		if len(e.last) >= maxExplainFingerprints {
+			return false
+		}
+	}
+	e.last[fingerprint] = now
+	return true
+}
+
+// explain runs EXPLAIN of the query with vars on pool and returns the plan, each row in a line
+// with the columns separated by " | ".
+func (e *explainPlugin) explain(ctx context.Context, pool *sql.DB, query string, vars []interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.options.timeout)
+	defer cancel()
+
+	prefix := "EXPLAIN "
+	if e.driver == DriverSQLite {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := pool.QueryContext(ctx, prefix+query, vars...)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	lines := []string{strings.Join(columns, " | ")}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if v.Valid {
+				fields[i] = v.String
+			} else {
+				fields[i] = "NULL"
+			}
+		}
+		lines = append(lines, strings.Join(fields, " | "))
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// explainAsync explains the query in background and logs the plan with the query, the slot of
+// running must be taken and it's released after done.
+func (e *explainPlugin) explainAsync(l *glog.Logger, pool *sql.DB, fingerprint string, tid string, query string, vars []interface{}, rows int64) {
+	go func() {
+		defer func() { <-e.running }()
+		plan, err := e.explain(context.Background(), pool, query, vars)
+		if err != nil {
+			l.Warn().Msg("gormlog explain slow query failed").String("fingerprint", fingerprint).String("tid", tid).
+				String("SQL", query).Error("error", err).Fire()
+			return
+		}
+		l.Warn().Msg("gormlog explain slow query").String("fingerprint", fingerprint).String("tid", tid).
+			String("SQL", query).Int64("rows", rows).String("plan", plan).Fire()
+	}()
+}
+
+func isSelect(sql string) bool {
+	sql = strings.TrimLeft(sql, " \t\r\n(")
+	return len(sql) >= 6 && strings.EqualFold(sql[:6], "select")
+}
@@ -0,0 +1,150 @@
+package gormwrap
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	require.Equal(t,
+		"select * from `users` where name = ? and id in (?+) and `t1`.age > ? limit ?",
+		NormalizeSQL("SELECT * FROM `users`  WHERE name = 'it''s'\n AND id IN (1, 2,3) AND `t1`.age > 1.5 LIMIT 10"),
+	)
+	require.Equal(t, Fingerprint("SELECT * FROM users WHERE id = 1"), Fingerprint("select * from users where id = 200"))
+	require.NotEqual(t, Fingerprint("SELECT * FROM users WHERE id = 1"), Fingerprint("SELECT * FROM users WHERE age = 1"))
+	require.Equal(t, Fingerprint("SELECT * FROM users WHERE id IN ($1, $2)"), Fingerprint("SELECT * FROM users WHERE id IN (1, 2, 3)"))
+}
+
+func TestExplainPlugin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := &syncBuffer{}
+	lp := glog.NewDefault().WithExporter(glog.StandardExporter(glog.NopWriterCloser(output)))
+
+	// The replica has an index that the primary does not have, so the plans are different.
+	primary := openTestSQLite(t, "primary.db")
+	replicaDB := openTestSQLite(t, "replica.db")
+	require.Nil(t, replicaDB.Exec("CREATE INDEX idx_replica_name ON resolver_users (name)").Error)
+	replicaSQL, err := replicaDB.DB()
+	require.Nil(t, err)
+	useTestResolver(t, ctx, primary, lp, []*replica{{host: "replica", db: replicaSQL}}, 0)
+	primarySQL, err := primary.DB()
+	require.Nil(t, err)
+	primaryExplain := openTestExplainDB(t, primary)
+	replicaExplain := openTestExplainDB(t, replicaDB)
+	pools := map[*sql.DB]*sql.DB{primarySQL: primaryExplain, replicaSQL: replicaExplain}
+	require.Nil(t, primary.Use(NewExplainPlugin(lp, time.Nanosecond, WithExplainDB(pools), WithExplainInterval(time.Hour))))
+
+	explained := func(fingerprint string) []string {
+		var lines []string
+		for _, line := range strings.Split(output.String(), "\n") {
+			if strings.Contains(line, "gormlog explain slow query") && strings.Contains(line, fingerprint) {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	// Explained on the replica with the bound parameters.
+	query := "SELECT * FROM `resolver_users` WHERE name = ?"
+	fingerprint := Fingerprint(query)
+	tctx := gtrace.ContextWithId(ctx, "explain-tid")
+	var users []resolverUser
+	require.Nil(t, primary.WithContext(tctx).Where("name = ?", "it's'; DROP TABLE resolver_users; --").Find(&users).Error)
+	require.Nil(t, primary.WithContext(tctx).Where("name = ?", "u2").Find(&users).Error)
+	require.Eventually(t, func() bool { return len(explained(fingerprint)) > 0 }, time.Second*5, time.Millisecond*10)
+	// Rate limited by fingerprint, the second query is not explained.
+	time.Sleep(time.Millisecond * 100)
+	lines := explained(fingerprint)
+	require.Equal(t, 1, len(lines))
+	require.Contains(t, lines[0], "explain-tid")
+	require.Contains(t, lines[0], "idx_replica_name")
+	require.Equal(t, 1, replicaExplain.Stats().OpenConnections)
+
+	// Explained on the primary in transaction.
+	query = "SELECT * FROM `resolver_users` WHERE name = ? AND id > ?"
+	fingerprint = Fingerprint(query)
+	err = primary.Transaction(func(tx *gorm.DB) error {
+		return tx.Where("name = ? AND id > ?", "u1", 0).Find(&users).Error
+	})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool { return len(explained(fingerprint)) > 0 }, time.Second*5, time.Millisecond*10)
+	require.NotContains(t, explained(fingerprint)[0], "idx_replica_name")
+	require.Contains(t, explained(fingerprint)[0], "USING INTEGER PRIMARY KEY")
+	require.Equal(t, 1, primaryExplain.Stats().OpenConnections)
+
+	// Not explained without the separate pool.
+	delete(pools, primarySQL)
+	query = "SELECT * FROM `resolver_users` WHERE id < ?"
+	fingerprint = Fingerprint(query)
+	require.Nil(t, primary.WithContext(UsePrimary(ctx)).Where("id < ?", 10).Find(&users).Error)
+	time.Sleep(time.Millisecond * 100)
+	require.Empty(t, explained(fingerprint))
+}
+
+// openTestExplainDB opens a separate single connection pool to the sqlite file of db.
+func openTestExplainDB(t *testing.T, db *gorm.DB) *sql.DB {
+	var file string
+	require.Nil(t, db.WithContext(UsePrimary(context.Background())).Raw("SELECT file FROM pragma_database_list WHERE name = ?", "main").Scan(&file).Error)
+	edb, err := openExplainDB(DriverSQLite, &Config{Database: file}, "")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = edb.Close() })
+	return edb
+}
+
+func TestLoggerSlowQuery(t *testing.T) {
+	output := &syncBuffer{}
+	lp := glog.NewDefault().WithExporter(glog.StandardExporter(glog.NopWriterCloser(output)))
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "slow.db")), &gorm.Config{
+		Logger: NewLogger(lp).WithSlowThreshold(time.Nanosecond),
+	})
+	require.Nil(t, err)
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+	defer func() { _ = sqlDB.Close() }()
+	require.Nil(t, db.AutoMigrate(&resolverUser{}))
+
+	fingerprint := Fingerprint("SELECT * FROM `resolver_users` WHERE id = ?")
+	counter := slowQueriesCounter.WithLabelValues(fingerprint)
+	before := testutil.ToFloat64(counter)
+	require.Nil(t, db.Find(&[]resolverUser{}, "id = ?", 1).Error)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	require.Contains(t, output.String(), fingerprint)
+
+	// Counted but not logged in silent level.
+	logged := output.String()
+	require.Nil(t, db.Session(&gorm.Session{Logger: db.Logger.LogMode(SilentLevel)}).Find(&[]resolverUser{}, "id = ?", 2).Error)
+	require.Equal(t, before+2, testutil.ToFloat64(counter))
+	require.Equal(t, logged, output.String())
+}
@@ -7,6 +7,7 @@ import (
 )
 
 // ExecuteFuncWithTxn execute a func with db txn.
+// Use ExecuteFuncWithRetryTxn to retry on deadlock or to join the transaction in context.
 func ExecuteFuncWithTxn(ctx context.Context, conn *gorm.DB, f func(tx *gorm.DB) error) (err error) {
 	tx := conn.Begin().WithContext(ctx)
 	if err = tx.Error; err != nil {
@@ -0,0 +1,94 @@
+package gormwrap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// KeyProvider provides the key encryption keys of encrypted columns. The keys are identified by
+// id, which is stored with the ciphertext, so the keys can be rotated by changing the current key
+// and keeping the old keys to decrypt.
+type KeyProvider interface {
+	// CurrentKey returns the id and key to encrypt.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key of id to decrypt.
+	Key(id string) ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider with the keys by id, current is the id of the key to
+// encrypt. The keys must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+func NewStaticKeyProvider(current string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("gormwrap: current key %q not found", current)
+	}
+	p := &staticKeyProvider{current: current, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, encryptedSeparator) {
+			return nil, fmt.Errorf("gormwrap: invalid key id %q", id)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("gormwrap: invalid size %d of key %q", len(key), id)
+		}
+		p.keys[id] = key
+	}
+	return p, nil
+}
+
+// CurrentKey implements KeyProvider.
+func (p *staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+// Key implements KeyProvider.
+func (p *staticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("gormwrap: encryption key %q not found", id)
+	}
+	return key, nil
+}
+
+// KeyConfig is the configuration of static keys.
+type KeyConfig struct {
+	// Current is the id of key to encrypt.
+	Current string `json:"current" yaml:"current" env:"CURRENT" validate:"required"`
+	// Keys is the base64 encoded keys by id, sample "k1:<base64>,k2:<base64>" in env.
+	Keys map[string]string `json:"keys" yaml:"keys" env:"KEYS" validate:"required"`
+}
+
+// NewKeyProviderFromConfig returns a KeyProvider with the static keys of cfg.
+func NewKeyProviderFromConfig(cfg *KeyConfig) (KeyProvider, error) {
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for id, encoded := range cfg.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("gormwrap: decode key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewStaticKeyProvider(cfg.Current, keys)
+}
+
+// NewFileKeyProvider returns a KeyProvider with the keys read from the JSON file in format of
+// KeyConfig, e.g. {"current": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}.
+func NewFileKeyProvider(filename string) (KeyProvider, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &KeyConfig{}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("gormwrap: parse key file %s: %w", filename, err)
+	}
+	return NewKeyProviderFromConfig(cfg)
+}
@@ -93,6 +93,15 @@ func (g *Logger) Error(ctx context.Context, format string, v ...interface{}) {
 }
 
 func (g *Logger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	slow := elapsed > g.SlowThreshold && g.SlowThreshold != 0
+	if slow {
+		// The slow queries are counted in any level.
+		sql, rows := fc()
+		fc = func() (string, int64) { return sql, rows }
+		slowQueriesCounter.WithLabelValues(Fingerprint(sql)).Inc()
+	}
+
 	if g.Level < SilentLevel {
 		return
 	}
@@ -101,14 +110,14 @@ func (g *Logger) Trace(ctx context.Context, begin time.Time, fc func() (string,
 		l = g.Output
 	}
 
-	elapsed := time.Since(begin)
 	switch {
 	case err != nil && g.Level >= ErrorLevel:
 		sql, rows := fc()
 		l.Error().Msg("gormlog trace ").String("SQL", sql).Int64("rows", rows).Error("error", err).Millisecond("elapsed", elapsed).Fire()
-	case elapsed > g.SlowThreshold && g.SlowThreshold != 0 && g.Level >= WarnLevel:
+	case slow && g.Level >= WarnLevel:
 		sql, rows := fc()
-		l.Warn().Msg("gormlog trace ").String("SQL", sql).Int64("rows", rows).Millisecond("elapsed", elapsed).Fire()
+		l.Warn().Msg("gormlog trace ").String("SQL", sql).Int64("rows", rows).Millisecond("elapsed", elapsed).
+			String("fingerprint", Fingerprint(sql)).Fire()
 	case g.Level >= InfoLevel:
 		sql, rows := fc()
 		l.Debug().Msg("gormlog trace ").String("SQL", sql).Int64("rows", rows).Millisecond("elapsed", elapsed).Fire()
@@ -0,0 +1,49 @@
+package gormwrap
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "gorm"
+
+var (
+	queryDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: "query",
+			Name:      "duration_seconds",
+			Help:      "The latencies in seconds of sql statements, partitioned by conn name, operation and table.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"name", "operation", "table"},
+	)
+
+	queryErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "query",
+			Name:      "errors_total",
+			Help:      "How many sql statements failed, partitioned by conn name, operation and table.",
+		},
+		[]string{"name", "operation", "table"},
+	)
+
+	slowQueriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "query",
+			Name:      "slow_total",
+			Help:      "How many sql statements slower than the SlowThreshold, partitioned by fingerprint.",
+		},
+		[]string{"fingerprint"},
+	)
+
+	dbStats = newDBStatsCollector()
+)
+
+func init() {
+	prometheus.MustRegister(queryDurationHistogram)
+	prometheus.MustRegister(queryErrorsCounter)
+	prometheus.MustRegister(slowQueriesCounter)
+	prometheus.MustRegister(dbStats)
+}
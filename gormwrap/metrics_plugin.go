@@ -0,0 +1,95 @@
+package gormwrap
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	metricsName     = "gormwrap:metrics"
+	metricsStartKey = "gormwrap:metrics_start"
+)
+
+// metricsPlugin implements gorm.Plugin to collect the latency and errors of statements.
+type metricsPlugin struct {
+	name string
+}
+
+func newMetricsPlugin(name string) gorm.Plugin {
+	return &metricsPlugin{name: name}
+}
+
+// Name implements gorm.Plugin.
+func (p *metricsPlugin) Name() string {
+	return metricsName
+}
+
+// Initialize implements gorm.Plugin.
+func (p *metricsPlugin) Initialize(db *gorm.DB) (err error) {
+	if err = db.Callback().Create().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Create().After("*").Register(metricsName+":after", p.after("create")); err != nil {
+		return
+	}
+	if err = db.Callback().Query().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Query().After("*").Register(metricsName+":after", p.after("query")); err != nil {
+		return
+	}
+	if err = db.Callback().Update().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Update().After("*").Register(metricsName+":after", p.after("update")); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().After("*").Register(metricsName+":after", p.after("delete")); err != nil {
+		return
+	}
+	if err = db.Callback().Row().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Row().After("*").Register(metricsName+":after", p.after("row")); err != nil {
+		return
+	}
+	if err = db.Callback().Raw().Before("*").Register(metricsName+":before", p.before); err != nil {
+		return
+	}
+	if err = db.Callback().Raw().After("*").Register(metricsName+":after", p.after("raw")); err != nil {
+		return
+	}
+	return
+}
+
+func (p *metricsPlugin) before(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+func (p *metricsPlugin) after(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := v.(time.Time)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		queryDurationHistogram.WithLabelValues(p.name, operation, table).Observe(time.Since(start).Seconds())
+		if isQueryError(db.Error) {
+			queryErrorsCounter.WithLabelValues(p.name, operation, table).Inc()
+		}
+	}
+}
+
+// isQueryError reports whether err is a failure of statement. The gorm.ErrRecordNotFound is not.
+func isQueryError(err error) bool {
+	return err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,471 @@
+package gormwrap
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DefaultMigrationTable is the default table to record the applied migrations.
+const DefaultMigrationTable = "schema_migrations"
+
+// sqlMigrationFileRegexp matches the file name of SQL migrations, e.g. "001_create_users.up.sql".
+var sqlMigrationFileRegexp = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_\-]+)\.(up|down)\.sql$`)
+
+// MigrateFunc applies or rolls back a migration.
+type MigrateFunc func(tx *gorm.DB) error
+
+// Migration is a versioned schema change.
+type Migration struct {
+	// Version must be unique and increasing, a timestamp such as 20220301150405 is recommended.
+	Version int64
+	Name    string
+	Up      MigrateFunc
+	// Down is optional, the migration cannot be rolled back if Down is nil.
+	Down MigrateFunc
+}
+
+// MigrationStatus is the state of a migration.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing is true if the migration is applied but not registered.
+	Missing bool
+}
+
+// MigrationResult is a migration applied or rolled back.
+type MigrationResult struct {
+	Version int64
+	Name    string
+	// Statements is the SQL statements of the migration, only recorded in dry-run mode.
+	Statements []string
+}
+
+// schemaMigration is the record in migration table.
+type schemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;type:varchar(255);not null"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+// MigratorOption used to change the behavior of Migrator.
+type MigratorOption func(o *migratorOptions)
+
+type migratorOptions struct {
+	table  string
+	locker Locker
+	dryRun bool
+}
+
+// WithMigrationTable sets the table to record the applied migrations. Defaults DefaultMigrationTable.
+func WithMigrationTable(table string) MigratorOption {
+	return func(o *migratorOptions) {
+		o.table = table
+	}
+}
+
+// WithMigrationLocker sets the lock to guard against concurrent runners, e.g. a getcd.Mutex.
+// Defaults to the advisory lock of database named by the migration table.
+func WithMigrationLocker(locker Locker) MigratorOption {
+	return func(o *migratorOptions) {
+		o.locker = locker
+	}
+}
+
+// WithMigrationDryRun controls whether to only report the migrations and SQL statements that
+// would be executed without changing the database. The queries in Go migrations return no rows
+// in dry-run mode.
+func WithMigrationDryRun(ok bool) MigratorOption {
+	return func(o *migratorOptions) {
+		o.dryRun = ok
+	}
+}
+
+// Migrator runs the versioned schema migrations and records them in the migration table.
+//
+// Each migration is executed in a transaction together with its record. Notice that the DDL
+// statements in MySQL commit implicitly, so a failed migration of MySQL must be idempotent or
+// fixed by hand.
+//
+// Usage:
+//
+//	m := gormwrap.NewMigrator(db)
+//	err := m.Register(&gormwrap.Migration{Version: 1, Name: "create_users", Up: ..., Down: ...})
+//	err = m.RegisterSQL(http.Dir("migrations"), "/")
+//	applied, err := m.Up(ctx)
+type Migrator struct {
+	db         *gorm.DB
+	opts       migratorOptions
+	migrations map[int64]*Migration
+}
+
+// NewMigrator creates a Migrator for db.
+func NewMigrator(db *gorm.DB, opts ...MigratorOption) *Migrator {
+	m := &Migrator{
+		db: db,
+		opts: migratorOptions{
+			table: DefaultMigrationTable,
+		},
+		migrations: make(map[int64]*Migration),
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	if m.opts.locker == nil {
+		m.opts.locker = newAdvisoryLocker(db, m.opts.table)
+	}
+	return m
+}
+
+// Register adds the migrations. Returns error if the version is duplicated.
+func (m *Migrator) Register(migrations ...*Migration) error {
+	for _, mi := range migrations {
+		if mi.Version <= 0 {
+			return fmt.Errorf("gormwrap: invalid migration version %d", mi.Version)
+		}
+		if mi.Up == nil {
+			return fmt.Errorf("gormwrap: migration %d has no Up", mi.Version)
+		}
+		if _, ok := m.migrations[mi.Version]; ok {
+			return fmt.Errorf("gormwrap: duplicated migration version %d", mi.Version)
+		}
+		m.migrations[mi.Version] = mi
+	}
+	return nil
+}
+
+// RegisterSQL adds the SQL migrations in dir of fs. The files are named "<version>_<name>.up.sql"
+// and "<version>_<name>.down.sql", and the statements in a file are separated by the semicolon
+// at the end of line.
+//
+// The embedded files can be used by http.FS(embedFS).
+func (m *Migrator) RegisterSQL(fs http.FileSystem, dir string) error {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := d.Readdir(-1)
+	_ = d.Close()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[int64]*Migration)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		matches := sqlMigrationFileRegexp.FindStringSubmatch(info.Name())
+		if matches == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(matches[1], 10, 64)
+		name := matches[2]
+
+		statements, err := readSQLStatements(fs, path.Join(dir, info.Name()))
+		if err != nil {
+			return err
+		}
+
+		mi, ok := files[version]
+		if !ok {
+			mi = &Migration{Version: version, Name: name}
+			files[version] = mi
+		} else if mi.Name != name {
+			return fmt.Errorf("gormwrap: migration %d has different names %q and %q", version, mi.Name, name)
+		}
+		if matches[3] == "up" {
+			mi.Up = execSQLStatements(statements)
+		} else {
+			mi.Down = execSQLStatements(statements)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(files))
+	for _, mi := range files {
+		migrations = append(migrations, mi)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return m.Register(migrations...)
+}
+
+// Status returns the status of the registered and applied migrations ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, mi := range m.migrations {
+		s := &MigrationStatus{Version: mi.Version, Name: mi.Name}
+		if r, ok := records[mi.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+		}
+		status = append(status, s)
+	}
+	for _, r := range records {
+		if _, ok := m.migrations[r.Version]; !ok {
+			status = append(status, &MigrationStatus{
+				Version: r.Version, Name: r.Name, Applied: true, AppliedAt: r.AppliedAt, Missing: true,
+			})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Up applies all pending migrations in order of version.
+//
+// NOTICE: Must set glog.Logger into the ctx by glog.WithContext
+func (m *Migrator) Up(ctx context.Context) ([]*MigrationResult, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies the pending migrations whose version is less than or equal to version.
+// All pending migrations are applied if version is 0. The migrations applied before a failed
+// one are returned with the error.
+//
+// NOTICE: Must set glog.Logger into the ctx by glog.WithContext
+func (m *Migrator) UpTo(ctx context.Context, version int64) (results []*MigrationResult, err error) {
+	err = m.withLock(ctx, func() error {
+		records, err := m.appliedRecords(ctx)
+		if err != nil {
+			return err
+		}
+		var pending []*Migration
+		for _, mi := range m.sorted() {
+			if version > 0 && mi.Version > version {
+				break
+			}
+			if _, ok := records[mi.Version]; !ok {
+				pending = append(pending, mi)
+			}
+		}
+		for _, mi := range pending {
+			result, err := m.run(ctx, mi, true)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	return
+}
+
+// Down rolls back the latest steps applied migrations in reverse order of version, the steps must
+// be greater than 0.
+//
+// NOTICE: Must set glog.Logger into the ctx by glog.WithContext
+func (m *Migrator) Down(ctx context.Context, steps int) (results []*MigrationResult, err error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("gormwrap: invalid steps %d of rolling back", steps)
+	}
+	err = m.withLock(ctx, func() error {
+		records, err := m.appliedRecords(ctx)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(records))
+		for v := range records {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		for _, v := range versions {
+			mi, ok := m.migrations[v]
+			if !ok {
+				return fmt.Errorf("gormwrap: applied migration %d is not registered", v)
+			}
+			if mi.Down == nil {
+				return fmt.Errorf("gormwrap: migration %d cannot be rolled back", v)
+			}
+		}
+		for _, v := range versions {
+			result, err := m.run(ctx, m.migrations[v], false)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	return
+}
+
+// run applies or rolls back the migration with its record in a transaction.
+func (m *Migrator) run(ctx context.Context, mi *Migration, up bool) (result *MigrationResult, err error) {
+	lp := glog.FromContext(ctx)
+	result = &MigrationResult{Version: mi.Version, Name: mi.Name}
+
+	fn, action := mi.Up, "up"
+	if !up {
+		fn, action = mi.Down, "down"
+	}
+
+	if m.opts.dryRun {
+		recorder := &sqlRecorder{Interface: logger.Discard}
+		tx := m.db.Session(&gorm.Session{DryRun: true, Logger: recorder, Context: ctx})
+		if err = runMigrateFunc(fn, tx); err != nil {
+			return nil, fmt.Errorf("gormwrap: dry-run migration %d %s: %w", mi.Version, action, err)
+		}
+		result.Statements = recorder.statements
+		lp.Info().Msg("gorm: dry-run migration").Int64("version", mi.Version).String("name", mi.Name).
+			String("action", action).Int("statements", len(result.Statements)).Fire()
+		return result, nil
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := runMigrateFunc(fn, tx); err != nil {
+			return err
+		}
+		if up {
+			return tx.Table(m.opts.table).Create(&schemaMigration{
+				Version: mi.Version, Name: mi.Name, AppliedAt: time.Now(),
+			}).Error
+		}
+		return tx.Table(m.opts.table).Delete(&schemaMigration{Version: mi.Version}).Error
+	})
+	if err != nil {
+		lp.Error().Msg("gorm: migration failed").Int64("version", mi.Version).String("name", mi.Name).
+			String("action", action).Error("error", err).Fire()
+		return nil, fmt.Errorf("gormwrap: migration %d %s: %w", mi.Version, action, err)
+	}
+	lp.Info().Msg("gorm: migration done").Int64("version", mi.Version).String("name", mi.Name).
+		String("action", action).Fire()
+	return result, nil
+}
+
+// withLock creates the migration table and calls f with the lock held.
+func (m *Migrator) withLock(ctx context.Context, f func() error) (err error) {
+	if m.opts.dryRun {
+		return f()
+	}
+	if m.opts.locker != nil {
+		if err = m.opts.locker.Lock(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := m.opts.locker.Unlock(context.Background()); uerr != nil && err == nil {
+				err = uerr
+			}
+		}()
+	}
+	if err = m.db.WithContext(UsePrimary(ctx)).Table(m.opts.table).AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+	return f()
+}
+
+// appliedRecords returns the applied migrations by version. They are read from the primary since
+// the replicas may lag behind the migrations just applied.
+func (m *Migrator) appliedRecords(ctx context.Context) (map[int64]*schemaMigration, error) {
+	db := m.db.WithContext(UsePrimary(ctx))
+	records := make(map[int64]*schemaMigration)
+	if !db.Migrator().HasTable(m.opts.table) {
+		return records, nil
+	}
+	var rows []*schemaMigration
+	if err := db.Table(m.opts.table).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		records[r.Version] = r
+	}
+	return records, nil
+}
+
+func (m *Migrator) sorted() []*Migration {
+	migrations := make([]*Migration, 0, len(m.migrations))
+	for _, mi := range m.migrations {
+		migrations = append(migrations, mi)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// runMigrateFunc calls fn and converts the panic to error, the queries may panic in dry-run mode.
+func runMigrateFunc(fn MigrateFunc, tx *gorm.DB) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(tx)
+}
+
+func execSQLStatements(statements []string) MigrateFunc {
+	return func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// readSQLStatements reads the file and splits the statements by the semicolon at the end of line.
+func readSQLStatements(fs http.FileSystem, name string) ([]string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+	var b strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if b.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(b.String()))
+			b.Reset()
+		}
+	}
+	if s := strings.TrimSpace(b.String()); s != "" {
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+// sqlRecorder implements gorm logger.Interface to record the SQL statements in dry-run mode.
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
@@ -0,0 +1,101 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Locker guards the migrations against concurrent runners.
+// Both getcd.Mutex and rediswrap.Mutex implement it.
+type Locker interface {
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+// advisoryLockRetryInterval is the interval to try to acquire the advisory lock again.
+const advisoryLockRetryInterval = time.Second
+
+// advisoryLocker implements Locker by the advisory lock of database, the lock is held by a
+// dedicated connection and released when the connection closed.
+type advisoryLocker struct {
+	db      *gorm.DB
+	name    string
+	dialect string
+	conn    *sql.Conn
+}
+
+// newAdvisoryLocker returns the advisory lock of MySQL or PostgreSQL. Returns nil for other
+// databases such as SQLite, which serializes writers by itself.
+func newAdvisoryLocker(db *gorm.DB, name string) Locker {
+	dialect := db.Dialector.Name()
+	if dialect != "mysql" && dialect != "postgres" {
+		return nil
+	}
+	return &advisoryLocker{db: db, name: name, dialect: dialect}
+}
+
+// Lock implements Locker.
+func (l *advisoryLocker) Lock(ctx context.Context) (err error) {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	var query string
+	switch l.dialect {
+	case "mysql":
+		// GET_LOCK returns 1 if acquired, 0 if timeout.
+		query = "SELECT COALESCE(GET_LOCK(?, 1), 0) = 1"
+	case "postgres":
+		query = "SELECT pg_try_advisory_lock(hashtext($1))"
+	}
+
+	for {
+		var ok bool
+		if err = conn.QueryRowContext(ctx, query, l.name).Scan(&ok); err != nil {
+			return fmt.Errorf("gormwrap: acquire advisory lock %q: %w", l.name, err)
+		}
+		if ok {
+			l.conn = conn
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockRetryInterval):
+		}
+	}
+}
+
+// Unlock implements Locker.
+func (l *advisoryLocker) Unlock(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	conn := l.conn
+	l.conn = nil
+	defer func() { _ = conn.Close() }()
+
+	var query string
+	switch l.dialect {
+	case "mysql":
+		query = "SELECT RELEASE_LOCK(?)"
+	case "postgres":
+		query = "SELECT pg_advisory_unlock(hashtext($1))"
+	}
+	_, err := conn.ExecContext(ctx, query, l.name)
+	return err
+}
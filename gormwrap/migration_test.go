@@ -0,0 +1,189 @@
+package gormwrap
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type countLocker struct {
+	locked   int
+	unlocked int
+}
+
+func (l *countLocker) Lock(context.Context) error {
+	l.locked++
+	return nil
+}
+
+func (l *countLocker) Unlock(context.Context) error {
+	l.unlocked++
+	return nil
+}
+
+func writeSQLMigrations(t *testing.T) http.FileSystem {
+	dir := t.TempDir()
+	files := map[string]string{
+		"002_create_jobs.up.sql": "-- jobs of user\nCREATE TABLE jobs (\n  id TEXT PRIMARY KEY,\n  user_id TEXT\n);\n" +
+			"CREATE INDEX idx_jobs_user ON jobs (user_id);\n",
+		"002_create_jobs.down.sql":  "DROP TABLE jobs;\n",
+		"003_add_job_status.up.sql": "ALTER TABLE jobs ADD COLUMN status TEXT;",
+		"README.md":                 "ignored",
+	}
+	for name, content := range files {
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return http.Dir(dir)
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	db := openTestSQLite(t, "migration.db")
+	locker := &countLocker{}
+
+	m := NewMigrator(db, WithMigrationLocker(locker))
+	require.Nil(t, m.Register(&Migration{
+		Version: 1,
+		Name:    "create_tags",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE tags (name TEXT PRIMARY KEY)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE tags").Error
+		},
+	}))
+	require.Nil(t, m.RegisterSQL(writeSQLMigrations(t), "/"))
+	require.NotNil(t, m.Register(&Migration{Version: 1, Name: "duplicated", Up: func(*gorm.DB) error { return nil }}))
+
+	// Dry run.
+	dry := NewMigrator(db, WithMigrationDryRun(true))
+	require.Nil(t, dry.RegisterSQL(writeSQLMigrations(t), "/"))
+	results, err := dry.Up(ctx)
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, []string{
+		"CREATE TABLE jobs (\n  id TEXT PRIMARY KEY,\n  user_id TEXT\n);",
+		"CREATE INDEX idx_jobs_user ON jobs (user_id);",
+	}, results[0].Statements)
+	require.False(t, db.Migrator().HasTable("jobs"))
+	require.False(t, db.Migrator().HasTable(DefaultMigrationTable))
+
+	results, err = m.UpTo(ctx, 2)
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+	require.True(t, db.Migrator().HasTable("tags"))
+	require.True(t, db.Migrator().HasTable("jobs"))
+	require.Equal(t, 1, locker.locked)
+	require.Equal(t, 1, locker.unlocked)
+
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, status, 3)
+	require.True(t, status[0].Applied)
+	require.Equal(t, "create_jobs", status[1].Name)
+	require.True(t, status[1].Applied)
+	require.False(t, status[2].Applied)
+
+	results, err = m.Up(ctx)
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, int64(3), results[0].Version)
+	require.True(t, db.Migrator().HasColumn("jobs", "status"))
+
+	_, err = m.Down(ctx, 0)
+	require.NotNil(t, err)
+	_, err = m.Down(ctx, -1)
+	require.NotNil(t, err)
+
+	// The migration 3 has no down.
+	_, err = m.Down(ctx, 1)
+	require.NotNil(t, err)
+
+	require.Nil(t, db.Table(DefaultMigrationTable).Delete(&schemaMigration{Version: 3}).Error)
+	results, err = m.Down(ctx, 5)
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, int64(2), results[0].Version)
+	require.Equal(t, int64(1), results[1].Version)
+	require.False(t, db.Migrator().HasTable("jobs"))
+	require.False(t, db.Migrator().HasTable("tags"))
+
+	status, err = m.Status(ctx)
+	require.Nil(t, err)
+	for _, s := range status {
+		require.False(t, s.Applied)
+	}
+}
+
+func TestMigratorWithReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel)))
+	defer cancel()
+	db := openTestSQLite(t, "migration.db")
+	replicaDB := openTestSQLite(t, "replica.db")
+	replicaSQL, err := replicaDB.DB()
+	require.Nil(t, err)
+	useTestResolver(t, ctx, db, glog.FromContext(ctx), []*replica{{host: "replica", db: replicaSQL}}, 0)
+
+	// The applied migrations are read from the primary, not the replica without migration table.
+	m := NewMigrator(db)
+	require.Nil(t, m.Register(&Migration{
+		Version: 1,
+		Name:    "create_tags",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE tags (name TEXT PRIMARY KEY)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE tags").Error
+		},
+	}))
+	_, err = m.Up(ctx)
+	require.Nil(t, err)
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, status, 1)
+	require.True(t, status[0].Applied)
+
+	results, err := m.Down(ctx, 1)
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.False(t, db.Migrator().HasTable("tags"))
+}
+
+func TestMigratorFailed(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	db := openTestSQLite(t, "migration.db")
+
+	m := NewMigrator(db)
+	require.Nil(t, m.Register(
+		&Migration{Version: 1, Name: "ok", Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE a (id INTEGER)").Error
+		}},
+		&Migration{Version: 2, Name: "bad", Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE TABLE b (id INTEGER)").Error; err != nil {
+				return err
+			}
+			return tx.Exec("INVALID SQL").Error
+		}},
+	))
+	results, err := m.Up(ctx)
+	require.NotNil(t, err)
+	require.Len(t, results, 1)
+
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.True(t, status[0].Applied)
+	require.False(t, status[1].Applied)
+	require.False(t, db.Migrator().HasTable("b"))
+
+	// Applied but not registered.
+	status, err = NewMigrator(db).Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, status, 1)
+	require.True(t, status[0].Missing)
+}
@@ -0,0 +1,277 @@
+package gormwrap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// The direction stored in cursor.
+const (
+	cursorNext = "n"
+	cursorPrev = "p"
+)
+
+// The type of values stored in cursor.
+const (
+	cursorInt    = "i"
+	cursorUint   = "u"
+	cursorFloat  = "f"
+	cursorString = "s"
+	cursorTime   = "t"
+	cursorBool   = "b"
+)
+
+// KeysetColumn is a column to sort of keyset pagination.
+type KeysetColumn struct {
+	Name string
+	Desc bool
+}
+
+// PageInfo is the cursors of the adjacent pages.
+type PageInfo struct {
+	// NextCursor is empty if no next page.
+	NextCursor string `json:"next_cursor"`
+	// PrevCursor is empty if no previous page.
+	PrevCursor string `json:"prev_cursor"`
+	HasNext    bool   `json:"has_next"`
+	HasPrev    bool   `json:"has_prev"`
+}
+
+// Paginator implements the keyset (cursor) pagination.
+//
+// Rows are sorted by the columns, and the next page starts after the last row of the current page
+// instead of skipping offset rows, so it's fast on large tables and no rows are skipped or
+// duplicated while data is changing.
+//
+// The combination of columns must be unique, so the last column is usually the primary key.
+// The ids generated by idgenerator are unique but not ordered by time, so sorts by the created
+// time first, e.g. NewPaginator(20, KeysetColumn{Name: "created", Desc: true}, KeysetColumn{Name: "id", Desc: true}).
+type Paginator struct {
+	limit   int
+	columns []KeysetColumn
+}
+
+// NewPaginator creates a Paginator that returns limit rows in every page.
+func NewPaginator(limit int, columns ...KeysetColumn) *Paginator {
+	if len(columns) == 0 {
+		panic("gormwrap NewPaginator: columns cannot be empty")
+	}
+	if limit <= 0 {
+		panic("gormwrap NewPaginator: limit must be greater than 0")
+	}
+	return &Paginator{limit: limit, columns: columns}
+}
+
+type keysetCursor struct {
+	Direction string        `json:"d"`
+	Values    []cursorValue `json:"v"`
+}
+
+type cursorValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+// Find queries the page after or before the cursor into dest, which must be a pointer to a slice
+// of struct. The first page is returned if the cursor is empty.
+//
+// The conditions, such as Condition.Build, should be applied to tx before calls Find. The ORDER
+// BY and LIMIT are set by Find. Returns qerror.InvalidParams if the cursor is invalid.
+func (p *Paginator) Find(tx *gorm.DB, cursor string, dest interface{}) (*PageInfo, error) {
+	for _, c := range p.columns {
+		if !columnNameRegexp.MatchString(c.Name) {
+			return nil, fmt.Errorf("gormwrap: invalid column name %q", c.Name)
+		}
+	}
+
+	direction := cursorNext
+	if cursor != "" {
+		cur, err := p.decodeCursor(cursor)
+		if err != nil {
+			return nil, qerror.InvalidParams.Format("cursor")
+		}
+		direction = cur.Direction
+		tx = tx.Clauses(clause.Where{Exprs: []clause.Expression{p.seekExpression(cur)}})
+	}
+
+	// Query in reverse order for the previous page.
+	reverse := direction == cursorPrev
+	orders := make([]clause.OrderByColumn, len(p.columns))
+	for i, c := range p.columns {
+		orders[i] = clause.OrderByColumn{Column: clause.Column{Name: c.Name}, Desc: c.Desc != reverse}
+	}
+
+	// Fetch one more row to know whether there are more rows. The schema of dest is parsed by the
+	// query, so the cursors are encoded with the statement of result rather than tx.
+	result := tx.Clauses(clause.OrderBy{Columns: orders}).Limit(p.limit + 1).Find(dest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	rv := reflect.ValueOf(dest).Elem()
+	more := rv.Len() > p.limit
+	if more {
+		rv.Set(rv.Slice(0, p.limit))
+	}
+	if reverse {
+		swap := reflect.Swapper(rv.Interface())
+		for i, j := 0, rv.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	info := &PageInfo{}
+	if reverse {
+		info.HasPrev = more
+		info.HasNext = true
+	} else {
+		info.HasNext = more
+		info.HasPrev = cursor != ""
+	}
+	if rv.Len() == 0 {
+		// The rows around the cursor may have been deleted, the client should start over.
+		return &PageInfo{}, nil
+	}
+
+	var err error
+	if info.HasNext {
+		if info.NextCursor, err = p.encodeCursor(result, cursorNext, rv.Index(rv.Len()-1)); err != nil {
+			return nil, err
+		}
+	}
+	if info.HasPrev {
+		if info.PrevCursor, err = p.encodeCursor(result, cursorPrev, rv.Index(0)); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// seekExpression builds the expanded tuple comparison for columns with different directions:
+// (c1 > v1) OR (c1 = v1 AND c2 > v2) OR (c1 = v1 AND c2 = v2 AND c3 > v3) ...
+func (p *Paginator) seekExpression(cur *decodedCursor) clause.Expression {
+	ors := make([]clause.Expression, 0, len(p.columns))
+	for i, c := range p.columns {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: clause.Column{Name: p.columns[j].Name}, Value: cur.values[j]})
+		}
+		column := clause.Column{Name: c.Name}
+		if c.Desc == (cur.Direction == cursorPrev) {
+			ands = append(ands, clause.Gt{Column: column, Value: cur.values[i]})
+		} else {
+			ands = append(ands, clause.Lt{Column: column, Value: cur.values[i]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+	if len(ors) == 1 {
+		// A single clause.Or is joined to the where of caller by OR.
+		return ors[0]
+	}
+	return clause.Or(ors...)
+}
+
+func (p *Paginator) encodeCursor(tx *gorm.DB, direction string, row reflect.Value) (string, error) {
+	sch := tx.Statement.Schema
+	if sch == nil {
+		return "", fmt.Errorf("gormwrap: unknown schema of dest")
+	}
+	cur := keysetCursor{Direction: direction, Values: make([]cursorValue, len(p.columns))}
+	for i, c := range p.columns {
+		field := sch.LookUpField(c.Name)
+		if field == nil {
+			return "", fmt.Errorf("gormwrap: column %q not found in %s", c.Name, sch.Name)
+		}
+		value, _ := field.ValueOf(tx.Statement.Context, reflect.Indirect(row))
+		cv, err := newCursorValue(value)
+		if err != nil {
+			return "", err
+		}
+		cur.Values[i] = cv
+	}
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodedCursor is the keysetCursor with values decoded.
+type decodedCursor struct {
+	keysetCursor
+	values []interface{}
+}
+
+func (p *Paginator) decodeCursor(cursor string) (*decodedCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	cur := &decodedCursor{}
+	if err = json.Unmarshal(data, &cur.keysetCursor); err != nil {
+		return nil, err
+	}
+	if cur.Direction != cursorNext && cur.Direction != cursorPrev {
+		return nil, fmt.Errorf("invalid direction %q", cur.Direction)
+	}
+	if len(cur.Values) != len(p.columns) {
+		return nil, fmt.Errorf("expected %d values, got %d", len(p.columns), len(cur.Values))
+	}
+	cur.values = make([]interface{}, len(cur.Values))
+	for i, cv := range cur.Values {
+		if cur.values[i], err = cv.decode(); err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+func newCursorValue(value interface{}) (cursorValue, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return cursorValue{Type: cursorTime, Value: v.Format(time.RFC3339Nano)}, nil
+	case string:
+		return cursorValue{Type: cursorString, Value: v}, nil
+	case bool:
+		return cursorValue{Type: cursorBool, Value: strconv.FormatBool(v)}, nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cursorValue{Type: cursorInt, Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cursorValue{Type: cursorUint, Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return cursorValue{Type: cursorFloat, Value: strconv.FormatFloat(rv.Float(), 'g', -1, 64)}, nil
+	case reflect.String:
+		return cursorValue{Type: cursorString, Value: rv.String()}, nil
+	}
+	return cursorValue{}, fmt.Errorf("gormwrap: unsupported cursor value type %T", value)
+}
+
+func (v cursorValue) decode() (interface{}, error) {
+	switch v.Type {
+	case cursorInt:
+		return strconv.ParseInt(v.Value, 10, 64)
+	case cursorUint:
+		return strconv.ParseUint(v.Value, 10, 64)
+	case cursorFloat:
+		return strconv.ParseFloat(v.Value, 64)
+	case cursorString:
+		return v.Value, nil
+	case cursorTime:
+		return time.Parse(time.RFC3339Nano, v.Value)
+	case cursorBool:
+		return strconv.ParseBool(v.Value)
+	}
+	return nil, fmt.Errorf("unsupported value type %q", v.Type)
+}
@@ -0,0 +1,132 @@
+package gormwrap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/idgenerator"
+	"github.com/stretchr/testify/require"
+)
+
+type paginationJob struct {
+	ID      string `gorm:"primaryKey"`
+	Status  string
+	Created int64
+}
+
+func TestPaginator(t *testing.T) {
+	db := openTestSQLite(t, "pagination.db")
+	require.Nil(t, db.AutoMigrate(&paginationJob{}))
+
+	// Several rows share the same created time, the id breaks the tie.
+	gen := idgenerator.New("job-")
+	for i := 0; i < 12; i++ {
+		id, err := gen.Take()
+		require.Nil(t, err)
+		require.Nil(t, db.Create(&paginationJob{ID: id, Status: "running", Created: int64(i / 3)}).Error)
+	}
+	require.Nil(t, db.Create(&paginationJob{ID: "job-0000000000000000", Status: "deleted", Created: 100}).Error)
+
+	var expected []paginationJob
+	require.Nil(t, db.Where("status = ?", "running").Order("created DESC, id DESC").Find(&expected).Error)
+
+	p := NewPaginator(5, KeysetColumn{Name: "created", Desc: true}, KeysetColumn{Name: "id", Desc: true})
+	query := func(cursor string) ([]paginationJob, *PageInfo) {
+		var jobs []paginationJob
+		info, err := p.Find(db.Model(&paginationJob{}).Where("status = ?", "running"), cursor, &jobs)
+		require.Nil(t, err)
+		return jobs, info
+	}
+
+	jobs, info := query("")
+	require.Equal(t, expected[0:5], jobs)
+	require.True(t, info.HasNext)
+	require.False(t, info.HasPrev)
+	require.Empty(t, info.PrevCursor)
+
+	jobs, info = query(info.NextCursor)
+	require.Equal(t, expected[5:10], jobs)
+	require.True(t, info.HasNext)
+	require.True(t, info.HasPrev)
+	prev := info.PrevCursor
+
+	jobs, info = query(info.NextCursor)
+	require.Equal(t, expected[10:12], jobs)
+	require.False(t, info.HasNext)
+	require.Empty(t, info.NextCursor)
+	require.True(t, info.HasPrev)
+
+	// Back to the first page.
+	jobs, info = query(prev)
+	require.Equal(t, expected[0:5], jobs)
+	require.False(t, info.HasPrev)
+	require.True(t, info.HasNext)
+
+	jobs, _ = query(info.NextCursor)
+	require.Equal(t, expected[5:10], jobs)
+
+	// The schema is parsed from dest without db.Model.
+	var jobs2 []paginationJob
+	info, err := p.Find(db, "", &jobs2)
+	require.Nil(t, err)
+	require.Equal(t, "job-0000000000000000", jobs2[0].ID)
+	require.NotEmpty(t, info.NextCursor)
+	require.Equal(t, expected[0:4], jobs2[1:])
+	_, err = p.Find(db.Where("status = ?", "running"), info.NextCursor, &jobs2)
+	require.Nil(t, err)
+	require.Equal(t, expected[4:9], jobs2)
+}
+
+func TestPaginatorSingleColumn(t *testing.T) {
+	db := openTestSQLite(t, "pagination.db")
+	require.Nil(t, db.AutoMigrate(&paginationJob{}))
+
+	for i := 1; i <= 6; i++ {
+		status := "running"
+		if i%2 == 0 {
+			status = "deleted"
+		}
+		require.Nil(t, db.Create(&paginationJob{ID: fmt.Sprintf("job-%d", i), Status: status}).Error)
+	}
+
+	// The seek condition is joined to the filter of caller by AND.
+	p := NewPaginator(1, KeysetColumn{Name: "id"})
+	var ids []string
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		var jobs []paginationJob
+		info, err := p.Find(db.Model(&paginationJob{}).Where("status = ?", "running"), cursor, &jobs)
+		require.Nil(t, err)
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		if !info.HasNext {
+			break
+		}
+		cursor = info.NextCursor
+	}
+	require.Equal(t, []string{"job-1", "job-3", "job-5"}, ids)
+}
+
+func TestPaginatorInvalidCursor(t *testing.T) {
+	db := openTestSQLite(t, "pagination.db")
+	require.Nil(t, db.AutoMigrate(&paginationJob{}))
+
+	p := NewPaginator(5, KeysetColumn{Name: "created"}, KeysetColumn{Name: "id"})
+	other := NewPaginator(5, KeysetColumn{Name: "id"})
+
+	var jobs []paginationJob
+	require.Nil(t, db.Create(&paginationJob{ID: "job-1", Created: 1}).Error)
+	require.Nil(t, db.Create(&paginationJob{ID: "job-2", Created: 2}).Error)
+	info, err := NewPaginator(1, KeysetColumn{Name: "id"}).Find(db.Model(&paginationJob{}), "", &jobs)
+	require.Nil(t, err)
+
+	for _, cursor := range []string{"invalid", "e30", info.NextCursor} {
+		_, err = p.Find(db.Model(&paginationJob{}), cursor, &jobs)
+		require.Equal(t, qerror.InvalidParams.Format("cursor"), err)
+	}
+	_, err = other.Find(db.Model(&paginationJob{}), info.NextCursor, &jobs)
+	require.Nil(t, err)
+	require.Equal(t, "job-2", jobs[0].ID)
+}
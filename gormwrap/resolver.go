@@ -0,0 +1,224 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/gorm"
+)
+
+const resolverName = "gormwrap:resolver"
+
+// defaultHealthCheckInterval is used if the interval of health check is not set.
+const defaultHealthCheckInterval = time.Second * 10
+
+// lockingReadRegexp matches the locking clauses of raw SELECT such as FOR UPDATE, FOR SHARE
+// and LOCK IN SHARE MODE.
+var lockingReadRegexp = regexp.MustCompile(`(?i)\b(FOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)|LOCK\s+IN\s+SHARE\s+MODE)\b`)
+
+type ctxUsePrimaryKey struct{}
+
+// UsePrimary returns a new context that forces the queries to be sent to the primary,
+// it's used for the read-after-write paths that cannot tolerate the replication lag.
+//
+// Usage: db.WithContext(gormwrap.UsePrimary(ctx)).Find(&users)
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxUsePrimaryKey{}, true)
+}
+
+func isUsePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	ok, _ := ctx.Value(ctxUsePrimaryKey{}).(bool)
+	return ok
+}
+
+// replica is a read-only database.
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy bool
+}
+
+// replicaConnPool is the gorm.ConnPool of a replica. The transactions are always begun on the
+// primary, even if the statement is switched to the replica by the previous query.
+type replicaConnPool struct {
+	*sql.DB
+	primary gorm.TxBeginner
+}
+
+// BeginTx implements gorm.TxBeginner.
+func (p *replicaConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return p.primary.BeginTx(ctx, opts)
+}
+
+// resolverPlugin implements gorm.Plugin to route the queries to replicas.
+//
+// Writes, Exec, transactions, locking reads, raw SQL that is not a plain SELECT and queries with
+// UsePrimary go to the primary. Other queries are load-balanced across the healthy replicas by round-robin, and fall back to
+// the primary if no replica is healthy.
+type resolverPlugin struct {
+	lp       *glog.Logger
+	primary  gorm.ConnPool
+	beginner gorm.TxBeginner
+	replicas []*replica
+
+	mu      sync.RWMutex
+	healthy []gorm.ConnPool
+	next    uint64
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newResolverPlugin(lp *glog.Logger, replicas []*replica) *resolverPlugin {
+	return &resolverPlugin{
+		lp:       lp,
+		replicas: replicas,
+		done:     make(chan struct{}),
+	}
+}
+
+// stop stops the health check and sends all queries to the primary.
+func (p *resolverPlugin) stop() {
+	p.stopOnce.Do(func() {
+		p.mu.Lock()
+		p.healthy = nil
+		p.mu.Unlock()
+		close(p.done)
+	})
+}
+
+// Name implements gorm.Plugin.
+func (p *resolverPlugin) Name() string {
+	return resolverName
+}
+
+// Initialize implements gorm.Plugin.
+func (p *resolverPlugin) Initialize(db *gorm.DB) (err error) {
+	beginner, ok := db.ConnPool.(gorm.TxBeginner)
+	if !ok {
+		return errors.New("gormwrap: the conn pool of primary does not support transaction")
+	}
+	p.primary = db.ConnPool
+	p.beginner = beginner
+
+	if err = db.Callback().Create().Before("*").Register(resolverName, p.switchPrimary); err != nil {
+		return
+	}
+	if err = db.Callback().Update().Before("*").Register(resolverName, p.switchPrimary); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().Before("*").Register(resolverName, p.switchPrimary); err != nil {
+		return
+	}
+	if err = db.Callback().Raw().Before("*").Register(resolverName, p.switchPrimary); err != nil {
+		return
+	}
+	if err = db.Callback().Query().Before("*").Register(resolverName, p.switchReplica); err != nil {
+		return
+	}
+	if err = db.Callback().Row().Before("*").Register(resolverName, p.switchReplica); err != nil {
+		return
+	}
+	return
+}
+
+// switchPrimary resets the conn pool that may be switched by previous query in the same statement.
+func (p *resolverPlugin) switchPrimary(db *gorm.DB) {
+	if p.inTransaction(db) {
+		return
+	}
+	db.Statement.ConnPool = p.primary
+}
+
+func (p *resolverPlugin) switchReplica(db *gorm.DB) {
+	if db.Error != nil || p.inTransaction(db) {
+		return
+	}
+	if _, ok := db.Statement.Clauses["FOR"]; ok || isUsePrimary(db.Statement.Context) || !p.isSafeRaw(db) {
+		db.Statement.ConnPool = p.primary
+		return
+	}
+	db.Statement.ConnPool = p.pick()
+}
+
+// isSafeRaw reports whether the statement is not a raw SQL, or is a raw SELECT without locking
+// clause. The raw SQL of db.Raw is built before the callbacks, so the clauses cannot be checked.
+func (p *resolverPlugin) isSafeRaw(db *gorm.DB) bool {
+	if db.Statement.SQL.Len() == 0 {
+		return true
+	}
+	raw := db.Statement.SQL.String()
+	return isSelect(raw) && !lockingReadRegexp.MatchString(raw)
+}
+
+func (p *resolverPlugin) inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// pick returns a healthy replica, or the primary if none.
+func (p *resolverPlugin) pick() gorm.ConnPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.healthy) == 0 {
+		return p.primary
+	}
+	n := atomic.AddUint64(&p.next, 1)
+	return p.healthy[n%uint64(len(p.healthy))]
+}
+
+// checkHealth pings the replicas and updates the healthy list.
+func (p *resolverPlugin) checkHealth(ctx context.Context, timeout time.Duration) {
+	var healthy []gorm.ConnPool
+	for _, r := range p.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+
+		ok := err == nil
+		if ok != r.healthy {
+			if ok {
+				p.lp.Info().Msg("gorm: replica becomes healthy, adds to rotation").String("host", r.host).Fire()
+			} else {
+				p.lp.Warn().Msg("gorm: replica becomes unhealthy, removes from rotation").String("host", r.host).Error("error", err).Fire()
+			}
+			r.healthy = ok
+		}
+		if ok {
+			healthy = append(healthy, &replicaConnPool{DB: r.db, primary: p.beginner})
+		}
+	}
+
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		// Keeps the queries on primary after stopped.
+	default:
+		p.healthy = healthy
+	}
+	p.mu.Unlock()
+}
+
+// healthCheckLoop runs checkHealth every interval until stopped. It's independent of the ctx
+// of NewConn, the replicas are closed by CloseConn.
+func (p *resolverPlugin) healthCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkHealth(context.Background(), interval)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package gormwrap
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type resolverUser struct {
+	ID   int64
+	Name string
+}
+
+func openTestSQLite(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{
+		Logger: &Logger{Level: SilentLevel, Output: glog.NewDefault()},
+	})
+	require.Nil(t, err)
+	require.Nil(t, db.AutoMigrate(&resolverUser{}))
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+// useTestResolver installs the resolverPlugin and stops it when the test finished.
+func useTestResolver(t *testing.T, ctx context.Context, db *gorm.DB, lp *glog.Logger, replicas []*replica, interval time.Duration) {
+	require.Nil(t, useResolver(ctx, db, lp, replicas, interval))
+	t.Cleanup(db.Config.Plugins[resolverName].(*resolverPlugin).stop)
+}
+
+func TestResolver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+
+	primary := openTestSQLite(t, "primary.db")
+	replicaDB := openTestSQLite(t, "replica.db")
+	require.Nil(t, replicaDB.Create(&resolverUser{ID: 1, Name: "from-replica"}).Error)
+
+	replicaSQL, err := replicaDB.DB()
+	require.Nil(t, err)
+	useTestResolver(t, ctx, primary, lp, []*replica{{host: "replica", db: replicaSQL}}, 0)
+
+	// Writes go to primary.
+	require.Nil(t, primary.Create(&resolverUser{ID: 1, Name: "from-primary"}).Error)
+
+	var user resolverUser
+	require.Nil(t, primary.First(&user, 1).Error)
+	require.Equal(t, "from-replica", user.Name)
+
+	require.Nil(t, primary.WithContext(UsePrimary(ctx)).First(&user, 1).Error)
+	require.Equal(t, "from-primary", user.Name)
+
+	err = primary.Transaction(func(tx *gorm.DB) error {
+		var u resolverUser
+		if err := tx.First(&u, 1).Error; err != nil {
+			return err
+		}
+		require.Equal(t, "from-primary", u.Name)
+		return nil
+	})
+	require.Nil(t, err)
+
+	// Raw SELECT goes to replica, the locking and non-SELECT raw SQL go to primary.
+	require.Nil(t, primary.Raw("SELECT * FROM resolver_users WHERE id = ?", 1).Scan(&user).Error)
+	require.Equal(t, "from-replica", user.Name)
+	require.Nil(t, primary.Raw("WITH u AS (SELECT * FROM resolver_users) SELECT * FROM u WHERE id = ?", 1).Scan(&user).Error)
+	require.Equal(t, "from-primary", user.Name)
+	require.True(t, lockingReadRegexp.MatchString("SELECT * FROM users WHERE id = 1 FOR UPDATE"))
+	require.True(t, lockingReadRegexp.MatchString("select * from users lock in share mode"))
+	require.False(t, lockingReadRegexp.MatchString("SELECT * FROM for_updates"))
+
+	// Transaction begun from a statement switched to replica is still on primary.
+	reused := primary.Model(&resolverUser{}).Where("id = ?", 1)
+	require.Nil(t, reused.First(&user).Error)
+	require.Equal(t, "from-replica", user.Name)
+	err = reused.Transaction(func(tx *gorm.DB) error {
+		var u resolverUser
+		if err := tx.Session(&gorm.Session{NewDB: true}).First(&u, 1).Error; err != nil {
+			return err
+		}
+		require.Equal(t, "from-primary", u.Name)
+		return nil
+	})
+	require.Nil(t, err)
+
+	// Statement reused after query still writes to primary.
+	tx := primary.Model(&resolverUser{}).Where("id = ?", 1)
+	var n int64
+	require.Nil(t, tx.Count(&n).Error)
+	require.Nil(t, tx.Update("name", "updated").Error)
+	require.Nil(t, primary.WithContext(UsePrimary(ctx)).First(&user, 1).Error)
+	require.Equal(t, "updated", user.Name)
+
+	// Unhealthy replica is removed from rotation.
+	require.Nil(t, replicaSQL.Close())
+	resolver := primary.Config.Plugins[resolverName].(*resolverPlugin)
+	resolver.checkHealth(ctx, time.Second)
+	require.Nil(t, primary.First(&user, 1).Error)
+	require.Equal(t, "updated", user.Name)
+}
+
+func TestResolverStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+
+	primary := openTestSQLite(t, "primary.db")
+	replicaDB := openTestSQLite(t, "replica.db")
+	require.Nil(t, replicaDB.Create(&resolverUser{ID: 1, Name: "from-replica"}).Error)
+	require.Nil(t, primary.Create(&resolverUser{ID: 1, Name: "from-primary"}).Error)
+	replicaSQL, err := replicaDB.DB()
+	require.Nil(t, err)
+	useTestResolver(t, ctx, primary, lp, []*replica{{host: "replica", db: replicaSQL}}, time.Millisecond*10)
+
+	var user resolverUser
+	require.Nil(t, primary.First(&user, 1).Error)
+	require.Equal(t, "from-replica", user.Name)
+
+	// The queries go to primary after stopped, even if the replica is still healthy.
+	resolver := primary.Config.Plugins[resolverName].(*resolverPlugin)
+	resolver.stop()
+	resolver.checkHealth(ctx, time.Second)
+	require.Nil(t, primary.First(&user, 1).Error)
+	require.Equal(t, "from-primary", user.Name)
+	resolver.stop()
+}
+
+func TestResolverContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+
+	primary := openTestSQLite(t, "primary.db")
+	replicaDB := openTestSQLite(t, "replica.db")
+	require.Nil(t, replicaDB.Create(&resolverUser{ID: 1, Name: "from-replica"}).Error)
+	replicaSQL, err := replicaDB.DB()
+	require.Nil(t, err)
+	useTestResolver(t, ctx, primary, lp, []*replica{{host: "replica", db: replicaSQL}}, time.Millisecond*10)
+
+	// The health check keeps running after the ctx of NewConn done.
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	require.Nil(t, replicaSQL.Ping())
+	var user resolverUser
+	require.Nil(t, primary.First(&user, 1).Error)
+	require.Equal(t, "from-replica", user.Name)
+}
@@ -0,0 +1,54 @@
+package gormwrap
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// SoftDelete is embedded by the models that are soft deleted. The Delete of gorm sets the
+// `deleted` column instead of removing the row, and the deleted rows are excluded from queries
+// unless tx.Unscoped() is used.
+//
+// Usage:
+//
+//	type Datasource struct {
+//		ID   string `gorm:"primaryKey"`
+//		Name string
+//		gormwrap.SoftDelete
+//	}
+type SoftDelete struct {
+	Deleted gorm.DeletedAt `gorm:"column:deleted;index" json:"-"`
+}
+
+// IsDeleted reports whether the row has been soft deleted.
+func (s SoftDelete) IsDeleted() bool {
+	return s.Deleted.Valid
+}
+
+type softDeleted interface {
+	IsDeleted() bool
+}
+
+// TakeResource loads the row whose id column is id into dest, which is a pointer to a model that
+// embeds SoftDelete. It returns qerror.ResourceNotExists if the row not exists, and
+// qerror.ResourceHasBeenDeleted with the resource and id if the row has been soft deleted.
+//
+// Usage: err := gormwrap.TakeResource(tx, &ds, "datasource", id)
+func TakeResource(tx *gorm.DB, dest interface{}, resource string, id string) error {
+	err := tx.Unscoped().Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: IDColumn}, Value: id}).
+		Take(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return qerror.ResourceNotExists.Format(id)
+	}
+	if err != nil {
+		return err
+	}
+	if s, ok := dest.(softDeleted); ok && s.IsDeleted() {
+		return qerror.ResourceHasBeenDeleted.Format(resource, id)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const tenantName = "gormwrap:tenant"
+
+// DefaultTenantColumn is the column of workspace id.
+const DefaultTenantColumn = "space_id"
+
+// ErrMissingTenant is returned when querying a TenantScoped model without the workspace in context.
+var ErrMissingTenant = errors.New("gormwrap: missing tenant (workspace) in context")
+
+// TenantScoped is implemented by the models that belong to a workspace.
+type TenantScoped interface {
+	// TenantColumn returns the column of workspace id, usually DefaultTenantColumn.
+	TenantColumn() string
+}
+
+type ctxTenantKey struct{}
+
+type ctxWithoutTenantScopeKey struct{}
+
+// WithTenant returns a new context with the workspace id.
+//
+// Usage: db.WithContext(gormwrap.WithTenant(ctx, spaceID)).Find(&jobs)
+func WithTenant(ctx context.Context, spaceID string) context.Context {
+	return context.WithValue(ctx, ctxTenantKey{}, spaceID)
+}
+
+// TenantFromContext returns the workspace id in ctx.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	spaceID, ok := ctx.Value(ctxTenantKey{}).(string)
+	return spaceID, ok && spaceID != ""
+}
+
+// WithoutTenantScope returns a new context that disables the tenant scope, it's used by the
+// admin jobs that access all workspaces.
+func WithoutTenantScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxWithoutTenantScopeKey{}, true)
+}
+
+func isWithoutTenantScope(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	ok, _ := ctx.Value(ctxWithoutTenantScopeKey{}).(bool)
+	return ok
+}
+
+// tenantPlugin implements gorm.Plugin to scope the queries, rows, updates and deletes of
+// TenantScoped models by the workspace in context.
+type tenantPlugin struct{}
+
+// NewTenantPlugin returns the gorm plugin that adds `space_id = ?` to the queries (including Row,
+// Rows and Scan), updates and deletes of TenantScoped models, the workspace id is read from context by TenantFromContext.
+// The statements without workspace in context are rejected by ErrMissingTenant unless the
+// context is WithoutTenantScope. Raw SQL is not scoped.
+//
+// Usage: err := db.Use(gormwrap.NewTenantPlugin())
+func NewTenantPlugin() gorm.Plugin {
+	return &tenantPlugin{}
+}
+
+// Name implements gorm.Plugin.
+func (p *tenantPlugin) Name() string {
+	return tenantName
+}
+
+// Initialize implements gorm.Plugin.
+func (p *tenantPlugin) Initialize(db *gorm.DB) (err error) {
+	if err = db.Callback().Query().Before("gorm:query").Register(tenantName, p.scope); err != nil {
+		return
+	}
+	if err = db.Callback().Row().Before("gorm:row").Register(tenantName, p.scope); err != nil {
+		return
+	}
+	if err = db.Callback().Update().Before("gorm:update").Register(tenantName, p.scope); err != nil {
+		return
+	}
+	if err = db.Callback().Delete().Before("gorm:delete").Register(tenantName, p.scope); err != nil {
+		return
+	}
+	return
+}
+
+func (p *tenantPlugin) scope(db *gorm.DB) {
+	// The raw SQL is built before the callbacks, so it cannot be scoped.
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.SQL.Len() > 0 {
+		return
+	}
+	scoped, ok := reflect.New(db.Statement.Schema.ModelType).Interface().(TenantScoped)
+	if !ok {
+		return
+	}
+
+	ctx := db.Statement.Context
+	if isWithoutTenantScope(ctx) {
+		return
+	}
+	spaceID, ok := TenantFromContext(ctx)
+	if !ok {
+		_ = db.AddError(ErrMissingTenant)
+		return
+	}
+
+	column := clause.Column{Table: clause.CurrentTable, Name: scoped.TenantColumn()}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Eq{Column: column, Value: spaceID}}})
+}
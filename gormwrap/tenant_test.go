@@ -0,0 +1,93 @@
+package gormwrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type tenantJob struct {
+	ID      string `gorm:"primaryKey"`
+	SpaceID string
+	Name    string
+}
+
+func (tenantJob) TenantColumn() string {
+	return DefaultTenantColumn
+}
+
+func TestTenantPlugin(t *testing.T) {
+	db := openTestSQLite(t, "tenant.db")
+	require.Nil(t, db.AutoMigrate(&tenantJob{}))
+	require.Nil(t, db.Use(NewTenantPlugin()))
+
+	require.Nil(t, db.Create([]*tenantJob{
+		{ID: "stj-1", SpaceID: "wks-1", Name: "a"},
+		{ID: "stj-2", SpaceID: "wks-1", Name: "b"},
+		{ID: "stj-3", SpaceID: "wks-2", Name: "c"},
+	}).Error)
+
+	ctx := context.Background()
+	ws1 := db.WithContext(WithTenant(ctx, "wks-1"))
+	ws2 := db.WithContext(WithTenant(ctx, "wks-2"))
+
+	var jobs []*tenantJob
+	require.Nil(t, ws1.Where("name <> ?", "").Order("id").Find(&jobs).Error)
+	require.Len(t, jobs, 2)
+	require.Equal(t, "stj-1", jobs[0].ID)
+
+	var count int64
+	require.Nil(t, ws2.Model(&tenantJob{}).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	// Row, Rows and Scan are scoped.
+	require.Nil(t, ws2.Model(&tenantJob{}).Select("count(*)").Row().Scan(&count))
+	require.Equal(t, int64(1), count)
+	var names []string
+	require.Nil(t, ws1.Model(&tenantJob{}).Order("id").Pluck("name", &names).Error)
+	require.Equal(t, []string{"a", "b"}, names)
+	var scanned []*tenantJob
+	require.Nil(t, ws2.Model(&tenantJob{}).Scan(&scanned).Error)
+	require.Len(t, scanned, 1)
+	require.Equal(t, "stj-3", scanned[0].ID)
+	rows, err := ws1.Model(&tenantJob{}).Rows()
+	require.Nil(t, err)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	require.Nil(t, rows.Close())
+	require.Equal(t, 2, n)
+
+	// Cannot touch the rows of other workspace by id.
+	result := ws2.Model(&tenantJob{}).Where("id = ?", "stj-1").Update("name", "x")
+	require.Nil(t, result.Error)
+	require.Equal(t, int64(0), result.RowsAffected)
+	result = ws2.Delete(&tenantJob{ID: "stj-1"})
+	require.Nil(t, result.Error)
+	require.Equal(t, int64(0), result.RowsAffected)
+	result = ws1.Delete(&tenantJob{ID: "stj-1"})
+	require.Nil(t, result.Error)
+	require.Equal(t, int64(1), result.RowsAffected)
+
+	// Rejected without workspace.
+	require.Equal(t, ErrMissingTenant, db.WithContext(ctx).Find(&jobs).Error)
+	require.Equal(t, ErrMissingTenant, db.Model(&tenantJob{}).Where("id = ?", "stj-2").Update("name", "x").Error)
+	require.Equal(t, ErrMissingTenant, db.Model(&tenantJob{}).Scan(&scanned).Error)
+	_, err = db.Model(&tenantJob{}).Rows()
+	require.Equal(t, ErrMissingTenant, err)
+
+	// Raw SQL is not scoped.
+	require.Nil(t, db.Raw("SELECT * FROM tenant_jobs ORDER BY id").Scan(&scanned).Error)
+	require.Len(t, scanned, 2)
+
+	// Admin jobs.
+	require.Nil(t, db.WithContext(WithoutTenantScope(ctx)).Find(&jobs).Error)
+	require.Len(t, jobs, 2)
+
+	// The models that not opt in are not scoped.
+	require.Nil(t, db.AutoMigrate(&versionJob{}))
+	var others []*versionJob
+	require.Nil(t, db.Find(&others).Error)
+}
@@ -0,0 +1,196 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ctxTxnKey struct{}
+
+// txnState is the transaction in context.
+type txnState struct {
+	tx    *gorm.DB
+	depth int
+}
+
+// ContextWithTx returns a new context with the transaction.
+func ContextWithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, ctxTxnKey{}, &txnState{tx: tx})
+}
+
+// TxFromContext returns the transaction in ctx, or nil if not found.
+func TxFromContext(ctx context.Context) *gorm.DB {
+	if s := txnStateFromContext(ctx); s != nil {
+		return s.tx
+	}
+	return nil
+}
+
+// DBFromContext returns the transaction in ctx, or conn with ctx if no transaction. It's used by
+// the repository functions that may be called in or out of a transaction.
+func DBFromContext(ctx context.Context, conn *gorm.DB) *gorm.DB {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	return conn.WithContext(ctx)
+}
+
+func txnStateFromContext(ctx context.Context) *txnState {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(ctxTxnKey{}).(*txnState)
+	return s
+}
+
+// TxnOption used to change the behavior of ExecuteFuncWithRetryTxn.
+type TxnOption func(o *txnOptions)
+
+type txnOptions struct {
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	txOptions  *sql.TxOptions
+}
+
+// WithTxnMaxRetries sets the max times to retry the transaction, 0 indicates no retries. Defaults 3.
+func WithTxnMaxRetries(n int) TxnOption {
+	return func(o *txnOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithTxnBackoff sets the backoff between retries, it doubles from min to max with jitter.
+// The min cannot be negative or greater than max. Defaults 20ms and 1s.
+func WithTxnBackoff(min, max time.Duration) TxnOption {
+	return func(o *txnOptions) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithTxnOptions sets the isolation level and read-only of the transaction.
+func WithTxnOptions(opts *sql.TxOptions) TxnOption {
+	return func(o *txnOptions) {
+		o.txOptions = opts
+	}
+}
+
+// IsRetryableError reports whether the transaction failed by err can be retried,
+// such as ErrDeadlock and ErrLockWaitTimeout.
+func IsRetryableError(err error) bool {
+	err = TranslateError(err)
+	return errors.Is(err, ErrDeadlock) || errors.Is(err, ErrLockWaitTimeout)
+}
+
+// ExecuteFuncWithRetryTxn execute f in a transaction, and retries the whole transaction with
+// backoff if failed by IsRetryableError. The f must be safe to be called more than once.
+//
+// The transaction is put into the ctx passed to f, use TxFromContext or DBFromContext to get it.
+// If there is already a transaction in ctx, f joins it by a SAVEPOINT: the changes of f are
+// rolled back to the savepoint if f returns error, and the error is returned without retries,
+// so the outermost call retries the whole transaction. The errors are translated by TranslateError.
+func ExecuteFuncWithRetryTxn(ctx context.Context, conn *gorm.DB, f func(ctx context.Context, tx *gorm.DB) error, opts ...TxnOption) error {
+	if s := txnStateFromContext(ctx); s != nil {
+		return executeFuncWithSavepoint(ctx, s, f)
+	}
+
+	o := txnOptions{
+		maxRetries: 3,
+		minBackoff: time.Millisecond * 20,
+		maxBackoff: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxRetries < 0 {
+		return fmt.Errorf("gormwrap: invalid txn max retries %d", o.maxRetries)
+	}
+	if o.minBackoff < 0 || o.minBackoff > o.maxBackoff {
+		return fmt.Errorf("gormwrap: invalid txn backoff from %s to %s", o.minBackoff, o.maxBackoff)
+	}
+
+	backoff := o.minBackoff
+	for attempt := 0; ; attempt++ {
+		err := executeFuncWithTxn(ctx, conn, f, o.txOptions)
+		if err == nil || attempt >= o.maxRetries || !IsRetryableError(err) {
+			return err
+		}
+
+		conn.Logger.Warn(ctx, "gorm: retry transaction after %d failures: %v", attempt+1, err)
+		// Full jitter.
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
+
+func executeFuncWithTxn(ctx context.Context, conn *gorm.DB, f func(ctx context.Context, tx *gorm.DB) error, opts *sql.TxOptions) (err error) {
+	var tx *gorm.DB
+	if opts != nil {
+		tx = conn.WithContext(ctx).Begin(opts)
+	} else {
+		tx = conn.WithContext(ctx).Begin()
+	}
+	if err = tx.Error; err != nil {
+		return
+	}
+
+	txCtx := ContextWithTx(ctx, tx)
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err = f(txCtx, tx); err != nil {
+		return TranslateError(err)
+	}
+	if err = tx.Commit().Error; err != nil {
+		return TranslateError(err)
+	}
+	committed = true
+	return
+}
+
+func executeFuncWithSavepoint(ctx context.Context, s *txnState, f func(ctx context.Context, tx *gorm.DB) error) (err error) {
+	// The errors of savepoint are added to a new session, so they don't fail the following
+	// statements of outer transaction that handles the error.
+	sp := s.tx.Session(&gorm.Session{})
+	name := fmt.Sprintf("gormwrap_sp_%d", s.depth+1)
+	if err = sp.SavePoint(name).Error; err != nil {
+		return TranslateError(err)
+	}
+
+	nested := &txnState{tx: s.tx, depth: s.depth + 1}
+	ok := false
+	defer func() {
+		if ok {
+			return
+		}
+		if rerr := sp.RollbackTo(name).Error; rerr != nil {
+			// The changes of f may be kept, the outer transaction should be rolled back.
+			s.tx.Logger.Error(ctx, "gorm: rollback to savepoint %s error: %v", name, rerr)
+		}
+	}()
+
+	if err = f(context.WithValue(ctx, ctxTxnKey{}, nested), s.tx); err != nil {
+		return TranslateError(err)
+	}
+	ok = true
+	return
+}
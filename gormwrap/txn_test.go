@@ -0,0 +1,134 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type txnUser struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+func createTxnUser(ctx context.Context, conn *gorm.DB, id string) error {
+	return DBFromContext(ctx, conn).Create(&txnUser{ID: id, Name: id}).Error
+}
+
+func countTxnUsers(t *testing.T, db *gorm.DB) int64 {
+	var count int64
+	require.Nil(t, db.Model(&txnUser{}).Count(&count).Error)
+	return count
+}
+
+func TestExecuteFuncWithRetryTxn(t *testing.T) {
+	db := openTestSQLite(t, "txn.db")
+	require.Nil(t, db.AutoMigrate(&txnUser{}))
+	ctx := context.Background()
+	opt := WithTxnBackoff(time.Millisecond, time.Millisecond*5)
+
+	// Retried on deadlock, the changes of failed attempts are rolled back.
+	attempts := 0
+	err := ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		attempts++
+		require.Equal(t, tx, TxFromContext(ctx))
+		if err := createTxnUser(ctx, db, "usr-1"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+		return nil
+	}, opt)
+	require.Nil(t, err)
+	require.Equal(t, 3, attempts)
+	require.Equal(t, int64(1), countTxnUsers(t, db))
+
+	// Retry limit.
+	attempts = 0
+	err = ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	}, opt, WithTxnMaxRetries(2))
+	require.True(t, errors.Is(err, ErrLockWaitTimeout))
+	require.Equal(t, 3, attempts)
+
+	// Not retryable.
+	attempts = 0
+	err = ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		attempts++
+		return errors.New("failed")
+	}, opt)
+	require.NotNil(t, err)
+	require.Equal(t, 1, attempts)
+
+	// Invalid options.
+	for _, o := range []TxnOption{WithTxnBackoff(-time.Millisecond, time.Second), WithTxnBackoff(time.Second, time.Millisecond), WithTxnMaxRetries(-1)} {
+		attempts = 0
+		err = ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+			attempts++
+			return nil
+		}, o)
+		require.NotNil(t, err)
+		require.Equal(t, 0, attempts)
+	}
+}
+
+func TestExecuteFuncWithRetryTxnNested(t *testing.T) {
+	db := openTestSQLite(t, "txn.db")
+	require.Nil(t, db.AutoMigrate(&txnUser{}))
+	ctx := context.Background()
+
+	err := ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		if err := createTxnUser(ctx, db, "usr-1"); err != nil {
+			return err
+		}
+		// The nested failure is rolled back to savepoint.
+		err := ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, nested *gorm.DB) error {
+			require.Equal(t, tx, nested)
+			if err := createTxnUser(ctx, db, "usr-2"); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		})
+		require.NotNil(t, err)
+
+		// The nested error is translated, and the outer transaction continues.
+		err = ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, _ *gorm.DB) error {
+			return createTxnUser(ctx, db, "usr-1")
+		})
+		require.True(t, errors.Is(err, ErrDuplicatedKey))
+		require.Nil(t, tx.Error)
+
+		return ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, _ *gorm.DB) error {
+			return ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, _ *gorm.DB) error {
+				return createTxnUser(ctx, db, "usr-3")
+			})
+		})
+	})
+	require.Nil(t, err)
+
+	var users []*txnUser
+	require.Nil(t, db.Order("id").Find(&users).Error)
+	require.Len(t, users, 2)
+	require.Equal(t, "usr-1", users[0].ID)
+	require.Equal(t, "usr-3", users[1].ID)
+
+	// The outer transaction is rolled back.
+	err = ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, tx *gorm.DB) error {
+		if err := ExecuteFuncWithRetryTxn(ctx, db, func(ctx context.Context, _ *gorm.DB) error {
+			return createTxnUser(ctx, db, "usr-4")
+		}); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	require.NotNil(t, err)
+	require.Equal(t, int64(2), countTxnUsers(t, db))
+
+}
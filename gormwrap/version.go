@@ -0,0 +1,63 @@
+package gormwrap
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/vergenerator"
+)
+
+// The columns used by UpdateWithVersion and TakeResource.
+const (
+	IDColumn      = "id"
+	VersionColumn = "version"
+)
+
+// UpdateWithVersion updates the row by optimistic concurrency control, it executes
+// `UPDATE ... SET ..., version = <new version> WHERE id = ? AND version = ?` with a new version
+// taken from gen, and returns the new version.
+//
+// The table must be specified by tx.Model or tx.Table, and tx can be the transaction of
+// ExecuteFuncWithTxn. Returns qerror.ResourceVersionConflict if the row has been modified by
+// others, and qerror.ResourceNotExists if the row not exists.
+//
+// Usage:
+//
+//	newVersion, err := gormwrap.UpdateWithVersion(tx.Table(tableNameStreamJob), verGen, id, version,
+//		map[string]interface{}{"name": name, "updated": time.Now().Unix()})
+func UpdateWithVersion(tx *gorm.DB, gen *vergenerator.VerGenerator, id interface{}, version int64, values map[string]interface{}) (int64, error) {
+	newVersion, err := gen.Take()
+	if err != nil {
+		return 0, err
+	}
+
+	updates := make(map[string]interface{}, len(values)+1)
+	for column, value := range values {
+		updates[column] = value
+	}
+	updates[VersionColumn] = newVersion
+
+	db := tx.Session(&gorm.Session{})
+	result := db.Where(clause.Eq{Column: clause.Column{Name: IDColumn}, Value: id}).
+		Where(clause.Eq{Column: clause.Column{Name: VersionColumn}, Value: version}).
+		Updates(updates)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return newVersion, nil
+	}
+
+	// Distinguishes the stale version from the missing row.
+	var count int64
+	if err = db.Where(clause.Eq{Column: clause.Column{Name: IDColumn}, Value: id}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, qerror.ResourceNotExists.Format(fmt.Sprint(id))
+	}
+	return 0, qerror.ResourceVersionConflict.Format(fmt.Sprint(id))
+}
@@ -0,0 +1,62 @@
+package gormwrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/DataWorkbench/common/constants"
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/vergenerator"
+)
+
+type versionJob struct {
+	ID      string `gorm:"primaryKey"`
+	Name    string
+	Version int64
+}
+
+func TestUpdateWithVersion(t *testing.T) {
+	db := openTestSQLite(t, "version.db")
+	require.Nil(t, db.AutoMigrate(&versionJob{}))
+	gen := vergenerator.New(vergenerator.WithInstanceId(constants.VerInstanceStreamJob))
+
+	version, err := gen.Take()
+	require.Nil(t, err)
+	require.Nil(t, db.Create(&versionJob{ID: "stj-1", Name: "foo", Version: version}).Error)
+
+	v1, err := UpdateWithVersion(db.Model(&versionJob{}), gen, "stj-1", version, map[string]interface{}{"name": "bar"})
+	require.Nil(t, err)
+	require.NotEqual(t, version, v1)
+
+	// Stale version.
+	_, err = UpdateWithVersion(db.Model(&versionJob{}), gen, "stj-1", version, map[string]interface{}{"name": "baz"})
+	require.Equal(t, qerror.ResourceVersionConflict.Format("stj-1"), err)
+
+	_, err = UpdateWithVersion(db.Model(&versionJob{}), gen, "stj-2", version, map[string]interface{}{"name": "baz"})
+	require.Equal(t, qerror.ResourceNotExists.Format("stj-2"), err)
+
+	// The transaction is rolled back on conflict.
+	err = ExecuteFuncWithTxn(context.Background(), db, func(tx *gorm.DB) error {
+		if err := tx.Model(&versionJob{}).Where("id = ?", "stj-1").Update("name", "qux").Error; err != nil {
+			return err
+		}
+		_, err := UpdateWithVersion(tx.Model(&versionJob{}), gen, "stj-1", version, map[string]interface{}{"name": "baz"})
+		return err
+	})
+	require.Equal(t, qerror.ResourceVersionConflict.Format("stj-1"), err)
+
+	var job versionJob
+	require.Nil(t, db.Take(&job, "id = ?", "stj-1").Error)
+	require.Equal(t, versionJob{ID: "stj-1", Name: "bar", Version: v1}, job)
+
+	err = ExecuteFuncWithTxn(context.Background(), db, func(tx *gorm.DB) error {
+		_, err := UpdateWithVersion(tx.Table("version_jobs"), gen, "stj-1", v1, map[string]interface{}{"name": "baz"})
+		return err
+	})
+	require.Nil(t, err)
+	require.Nil(t, db.Take(&job, "id = ?", "stj-1").Error)
+	require.Equal(t, "baz", job.Name)
+}
@@ -2,6 +2,7 @@ package grpcwrap
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"strings"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/tlsutil"
 )
 
 // ClientConn is an type aliases to make caller don't have to introduce "google.golang.org/grpc"
@@ -36,7 +38,25 @@ func (cc *ClientConn) Close() error {
 // ClientConfig used to create an connection to grpc server
 type ClientConfig struct {
 	// Address sample "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003"
+	// The requests are balanced between the addresses by Balancer.
 	Address string `json:"address" yaml:"address" env:"ADDRESS" validate:"required"`
+	// Balancer is the load balancing policy of multiple addresses, "round_robin" sends the requests to
+	// the ready addresses in turn, and "pick_first" sends all requests to the first ready address and
+	// fails over to the next one in order.
+	Balancer string `json:"balancer" yaml:"balancer" env:"BALANCER,default=round_robin" validate:"omitempty,oneof=round_robin pick_first"`
+
+	// TLSEnabled controls whether connects to server with TLS. The insecure mode is used if false,
+	// only for local development.
+	TLSEnabled bool `json:"tls_enabled" yaml:"tls_enabled" env:"TLS_ENABLED,default=false"`
+	// TLSCAFile is the CA bundle used to verify the server certificate, the system root CAs is used if empty.
+	TLSCAFile string `json:"tls_ca_file" yaml:"tls_ca_file" env:"TLS_CA_FILE"`
+	// The client key pair for mTLS. Both are optional, they're reloaded if the files changed.
+	TLSCertFile string `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE" validate:"required_with=TLSKeyFile"`
+	TLSKeyFile  string `json:"tls_key_file"  yaml:"tls_key_file"  env:"TLS_KEY_FILE"  validate:"required_with=TLSCertFile"`
+	// TLSServerName overrides the server name used to verify the server certificate.
+	TLSServerName string `json:"tls_server_name" yaml:"tls_server_name" env:"TLS_SERVER_NAME"`
+	// TLSInsecureSkipVerify disables verification of server certificate. Only used for testing.
+	TLSInsecureSkipVerify bool `json:"tls_insecure_skip_verify" yaml:"tls_insecure_skip_verify" env:"TLS_INSECURE_SKIP_VERIFY,default=false"`
 }
 
 // NewConn return an new grpc.ClientConn
@@ -52,20 +72,51 @@ func NewConn(ctx context.Context, cfg *ClientConfig) (conn *ClientConn, err erro
 
 	lp.Info().Msg("gRPC client: connecting to server").String("address", cfg.Address).Fire()
 
-	// TODO: support balance
 	// address format "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003"
 	hosts := strings.Split(strings.ReplaceAll(cfg.Address, " ", ""), ",")
-	if len(hosts) == 0 {
+	if len(hosts) == 0 || hosts[0] == "" {
 		err = fmt.Errorf("invalid address: %s", cfg.Address)
 		return
 	}
+	// The multiple addresses are resolved by the static resolver.
+	target := hosts[0]
+	if len(hosts) > 1 {
+		target = StaticScheme + ":///" + strings.Join(hosts, ",")
+	}
+
+	balancer := cfg.Balancer
+	if balancer == "" {
+		balancer = BalancerRoundRobin
+	}
+	if balancer != BalancerRoundRobin && balancer != BalancerPickFirst {
+		err = fmt.Errorf("unsupported balancer: %s", cfg.Balancer)
+		return
+	}
 
 	tracer := gtrace.TracerFromContext(ctx)
 
 	var dialOpts []grpc.DialOption
-	// Set and add insecure
-	//dialOpts = append(dialOpts, grpc.WithInsecure())
-	dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Set and add load balancing policy
+	dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {}}]}`, balancer)))
+
+	if cfg.TLSEnabled {
+		var creds *reloadableCredentials
+		creds, err = newReloadableCredentials(lp, &tlsutil.Options{
+			CAFile:             cfg.TLSCAFile,
+			CertFile:           cfg.TLSCertFile,
+			KeyFile:            cfg.TLSKeyFile,
+			ServerName:         cfg.TLSServerName,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		}, tls.NoClientCert)
+		if err != nil {
+			return
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+	} else {
+		// Set and add insecure
+		//dialOpts = append(dialOpts, grpc.WithInsecure())
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
 
 	// set and add connect params
 	dialOpts = append(dialOpts, grpc.WithConnectParams(grpc.ConnectParams{
@@ -108,10 +159,11 @@ func NewConn(ctx context.Context, cfg *ClientConfig) (conn *ClientConn, err erro
 	))
 
 	var c *grpc.ClientConn
-	c, err = grpc.DialContext(ctx, hosts[0], dialOpts...)
+	c, err = grpc.DialContext(ctx, target, dialOpts...)
 	if err != nil {
 		return
 	}
+	go watchConnectivityState(ctx, lp, cfg.Address, c)
 
 	conn = &ClientConn{ClientConn: c}
 	return
@@ -0,0 +1,63 @@
+package grpcwrap
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/DataWorkbench/glog"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/DataWorkbench/common/utils/tlsutil"
+)
+
+// reloadableCredentials implements credentials.TransportCredentials by TLS with the certificates
+// of tlsutil.Reloader, the files are reloaded if changed before each handshake.
+type reloadableCredentials struct {
+	reloader   *tlsutil.Reloader
+	clientAuth tls.ClientAuthType
+	serverName string
+}
+
+func newReloadableCredentials(lp *glog.Logger, opts *tlsutil.Options, clientAuth tls.ClientAuthType) (*reloadableCredentials, error) {
+	reloader, err := tlsutil.NewReloader(opts, func(err error) {
+		lp.Error().Error("gRPC: reload tls certificates error", err).Fire()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reloadableCredentials{
+		reloader:   reloader,
+		clientAuth: clientAuth,
+		serverName: opts.ServerName,
+	}, nil
+}
+
+// ClientHandshake implements credentials.TransportCredentials.
+func (c *reloadableCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg := c.reloader.ClientConfig()
+	cfg.ServerName = c.serverName
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, conn)
+}
+
+// ServerHandshake implements credentials.TransportCredentials.
+func (c *reloadableCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.reloader.ServerConfig(c.clientAuth)).ServerHandshake(conn)
+}
+
+// Info implements credentials.TransportCredentials.
+func (c *reloadableCredentials) Info() credentials.ProtocolInfo {
+	return credentials.NewTLS(&tls.Config{ServerName: c.serverName}).Info()
+}
+
+// Clone implements credentials.TransportCredentials.
+func (c *reloadableCredentials) Clone() credentials.TransportCredentials {
+	nc := *c
+	return &nc
+}
+
+// OverrideServerName implements credentials.TransportCredentials.
+func (c *reloadableCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
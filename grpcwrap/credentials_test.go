@@ -0,0 +1,142 @@
+package grpcwrap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-playground/validator/v10"
+	"github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// writeTestCerts generates a self-signed CA and a key pair of "localhost" signed by it for both
+// server and client auth, and writes them into dir.
+func writeTestCerts(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "grpcwrap-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, &key.PublicKey, caKey)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return
+}
+
+// startTestServer starts the server on a random local port and returns the address.
+func startTestServer(t *testing.T, ctx context.Context, cfg *ServerConfig) (*Server, string) {
+	s, err := NewServer(ctx, cfg)
+	require.Nil(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() { _ = s.gRPC.Serve(lis) }()
+	t.Cleanup(s.gRPC.Stop)
+	return s, lis.Addr().String()
+}
+
+func checkHealth(ctx context.Context, conn *ClientConn) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc_retry.Disable())
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	caFile, certFile, keyFile := writeTestCerts(t, t.TempDir())
+
+	_, address := startTestServer(t, ctx, &ServerConfig{
+		TLSEnabled:    true,
+		TLSCAFile:     caFile,
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSClientAuth: "require_and_verify",
+	})
+
+	conn, err := NewConn(ctx, &ClientConfig{
+		Address:       address,
+		TLSEnabled:    true,
+		TLSCAFile:     caFile,
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSServerName: "localhost",
+	})
+	require.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	require.Nil(t, checkHealth(ctx, conn))
+
+	// Without client certificate.
+	noCert, err := NewConn(ctx, &ClientConfig{Address: address, TLSEnabled: true, TLSCAFile: caFile})
+	require.Nil(t, err)
+	defer func() { _ = noCert.Close() }()
+	require.NotNil(t, checkHealth(ctx, noCert))
+
+	// Insecure.
+	insecureConn, err := NewConn(ctx, &ClientConfig{Address: address})
+	require.Nil(t, err)
+	defer func() { _ = insecureConn.Close() }()
+	require.NotNil(t, checkHealth(ctx, insecureConn))
+}
+
+func TestNewServerInvalidTLS(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.FatalLevel))
+	_, certFile, keyFile := writeTestCerts(t, t.TempDir())
+
+	_, err := NewServer(ctx, &ServerConfig{TLSEnabled: true, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientAuth: "unknown"})
+	require.NotNil(t, err)
+	_, err = NewServer(ctx, &ServerConfig{TLSEnabled: true, TLSCertFile: certFile, TLSKeyFile: certFile})
+	require.NotNil(t, err)
+
+	// The client certificates cannot be verified without CA bundle.
+	validate := validator.New()
+	for _, mode := range []string{"verify_if_given", "require_and_verify"} {
+		cfg := &ServerConfig{Address: "127.0.0.1:0", TLSEnabled: true, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientAuth: mode}
+		_, err = NewServer(ctx, cfg)
+		require.NotNil(t, err, mode)
+		require.NotNil(t, validate.Struct(cfg), mode)
+	}
+	cfg := &ServerConfig{Address: "127.0.0.1:0", TLSEnabled: true, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientAuth: "require_any"}
+	require.Nil(t, validate.Struct(cfg))
+}
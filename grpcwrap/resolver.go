@@ -0,0 +1,75 @@
+package grpcwrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/DataWorkbench/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+)
+
+// StaticScheme is the scheme of resolver that resolves the target to the fixed addresses,
+// sample "static:///127.0.0.1:50001,127.0.0.1:50002".
+const StaticScheme = "static"
+
+// The load balancing policies of ClientConfig.Balancer.
+const (
+	BalancerRoundRobin = "round_robin"
+	BalancerPickFirst  = "pick_first"
+)
+
+func init() {
+	resolver.Register(&staticResolverBuilder{})
+}
+
+type staticResolverBuilder struct{}
+
+// Build implements resolver.Builder.
+func (*staticResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	var addrs []resolver.Address
+	for _, host := range strings.Split(target.Endpoint, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			// The ServerName is used as the authority and to verify the server certificate.
+			addrs = append(addrs, resolver.Address{Addr: host, ServerName: host})
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("grpcwrap: no address in target %s", target.Endpoint)
+	}
+	if err := cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return nil, err
+	}
+	return staticResolver{}, nil
+}
+
+// Scheme implements resolver.Builder.
+func (*staticResolverBuilder) Scheme() string {
+	return StaticScheme
+}
+
+// staticResolver does nothing since the addresses never change.
+type staticResolver struct{}
+
+// ResolveNow implements resolver.Resolver.
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver.
+func (staticResolver) Close() {}
+
+// watchConnectivityState logs the connectivity state changes of conn until it's closed or ctx done.
+func watchConnectivityState(ctx context.Context, lp *glog.Logger, address string, conn *grpc.ClientConn) {
+	for {
+		state := conn.GetState()
+		if state == connectivity.TransientFailure {
+			lp.Warn().Msg("gRPC client: connectivity state changed").String("address", address).String("state", state.String()).Fire()
+		} else {
+			lp.Info().Msg("gRPC client: connectivity state changed").String("address", address).String("state", state.String()).Fire()
+		}
+		if state == connectivity.Shutdown || !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+	}
+}
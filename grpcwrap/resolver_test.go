@@ -0,0 +1,76 @@
+package grpcwrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+// checkHealthPeer calls the health check and returns the address of server.
+func checkHealthPeer(ctx context.Context, conn *ClientConn) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	var p peer.Peer
+	_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Peer(&p), grpc_retry.Disable())
+	if err != nil {
+		return "", err
+	}
+	return p.Addr.String(), nil
+}
+
+func TestBalancer(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+
+	servers := make([]*Server, 3)
+	addresses := make([]string, 3)
+	for i := range servers {
+		servers[i], addresses[i] = startTestServer(t, ctx, &ServerConfig{})
+	}
+
+	t.Run("round_robin", func(t *testing.T) {
+		conn, err := NewConn(ctx, &ClientConfig{Address: strings.Join(addresses, ", "), Balancer: BalancerRoundRobin})
+		require.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+
+		seen := make(map[string]bool)
+		require.Eventually(t, func() bool {
+			if addr, err := checkHealthPeer(ctx, conn); err == nil {
+				seen[addr] = true
+			}
+			return len(seen) == len(addresses)
+		}, time.Second*10, time.Millisecond*10)
+		for _, addr := range addresses {
+			require.True(t, seen[addr])
+		}
+	})
+
+	t.Run("pick_first", func(t *testing.T) {
+		conn, err := NewConn(ctx, &ClientConfig{Address: strings.Join(addresses, ","), Balancer: BalancerPickFirst})
+		require.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+
+		for i := 0; i < 5; i++ {
+			addr, err := checkHealthPeer(ctx, conn)
+			require.Nil(t, err)
+			require.Equal(t, addresses[0], addr)
+		}
+
+		// Fails over to the next address.
+		servers[0].gRPC.Stop()
+		require.Eventually(t, func() bool {
+			addr, err := checkHealthPeer(ctx, conn)
+			return err == nil && addr == addresses[1]
+		}, time.Second*10, time.Millisecond*10)
+	})
+
+	_, err := NewConn(ctx, &ClientConfig{Address: addresses[0], Balancer: "random"})
+	require.NotNil(t, err)
+}
@@ -2,6 +2,8 @@ package grpcwrap
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"math"
 	"net"
 	"reflect"
@@ -18,6 +20,7 @@ import (
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/tlsutil"
 )
 
 // GServer is an type aliases to make caller don't have to introduce "google.golang.org/grpc"
@@ -29,6 +32,18 @@ type GServer = grpc.Server
 type ServerConfig struct {
 	// Listening address of the grpc server.
 	Address string `json:"address" yaml:"address" env:"ADDRESS" validate:"required"`
+
+	// TLSEnabled controls whether serves with TLS. The insecure mode is used if false, only for local development.
+	TLSEnabled bool `json:"tls_enabled" yaml:"tls_enabled" env:"TLS_ENABLED,default=false"`
+	// The server key pair, they're reloaded if the files changed.
+	TLSCertFile string `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE" validate:"required_if=TLSEnabled true"`
+	TLSKeyFile  string `json:"tls_key_file"  yaml:"tls_key_file"  env:"TLS_KEY_FILE"  validate:"required_if=TLSEnabled true"`
+	// TLSCAFile is the CA bundle used to verify the client certificates for mTLS, it's required
+	// if TLSClientAuth verifies the client certificates.
+	TLSCAFile string `json:"tls_ca_file" yaml:"tls_ca_file" env:"TLS_CA_FILE" validate:"required_if=TLSClientAuth verify_if_given,required_if=TLSClientAuth require_and_verify"`
+	// TLSClientAuth is the policy of client certificates, one of "none", "request", "require_any",
+	// "verify_if_given" and "require_and_verify". Sets "require_and_verify" for mTLS.
+	TLSClientAuth string `json:"tls_client_auth" yaml:"tls_client_auth" env:"TLS_CLIENT_AUTH,default=none" validate:"omitempty,oneof=none request require_any verify_if_given require_and_verify"`
 }
 
 // Server is an wrapper for gRPC server.
@@ -53,6 +68,30 @@ func NewServer(ctx context.Context, cfg *ServerConfig) (s *Server, err error) {
 
 	var srvOpts []grpc.ServerOption
 
+	// Set and add transport credentials
+	if cfg.TLSEnabled {
+		var clientAuth tls.ClientAuthType
+		if clientAuth, err = tlsutil.ParseClientAuth(cfg.TLSClientAuth); err != nil {
+			return
+		}
+		// Without CA bundle, the client certificates are verified by the system roots.
+		verify := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+		if verify && cfg.TLSCAFile == "" {
+			err = fmt.Errorf("tls ca file is required by client auth: %s", cfg.TLSClientAuth)
+			return
+		}
+		var creds *reloadableCredentials
+		creds, err = newReloadableCredentials(lp, &tlsutil.Options{
+			CAFile:   cfg.TLSCAFile,
+			CertFile: cfg.TLSCertFile,
+			KeyFile:  cfg.TLSKeyFile,
+		}, clientAuth)
+		if err != nil {
+			return
+		}
+		srvOpts = append(srvOpts, grpc.Creds(creds))
+	}
+
 	// Set and add keepalive enforcement policy
 	// TODO: set keepalive parameters by config
 	srvOpts = append(srvOpts, grpc.KeepaliveEnforcementPolicy(
@@ -82,3 +82,15 @@ func FromGRPC(err error) *Error {
 		zhCN:   pe.ZhCn,
 	}
 }
+
+// Is reports whether the target has the same error code with e.
+// It makes errors.Is works with the errors created by Format.
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case *Error:
+		return t != nil && e.code == t.code
+	case Error:
+		return e.code == t.code
+	}
+	return false
+}
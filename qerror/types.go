@@ -59,6 +59,14 @@ var (
 		zhCN:   "资源 [%s] 已存在",
 	}
 
+	// ResourceVersionConflict render message if updating resource has been modified by others.
+	ResourceVersionConflict = &Error{
+		code:   "ResourceVersionConflict",
+		status: 409,
+		enUS:   "The resource [%s] has been modified by others, please refresh and retry.",
+		zhCN:   "资源 [%s] 已被他人修改, 请刷新后重试",
+	}
+
 	// ResourceIsInUsing render message if be deletion resource is using by other module.
 	ResourceIsInUsing = &Error{
 		code:   "ResourceIsInUsing",
@@ -72,6 +80,14 @@ var (
 		enUS:   "The used resource [%s %s] has been deleted.",
 		zhCN:   "依赖的资源 [%s %s] 已经被删除",
 	}
+
+	// TooManyRequests render message if the request rate exceeds the limit.
+	TooManyRequests = &Error{
+		code:   "TooManyRequests",
+		status: http.StatusTooManyRequests,
+		enUS:   "Request rate exceeds the limit, please retry after [%d] seconds.",
+		zhCN:   "请求频率超出限制, 请在 [%d] 秒后重试.",
+	}
 )
 
 // parameters error
@@ -0,0 +1,337 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// ErrCacheMiss is returned by Cache.Get if the key not exists in cache.
+var ErrCacheMiss = errors.New("rediswrap: cache miss")
+
+// The first byte of values stored in redis indicates whether it's a negative cached.
+const (
+	cacheFlagValue    byte = 'v'
+	cacheFlagNotFound byte = 'n'
+)
+
+const (
+	cacheResultHit         = "hit"
+	cacheResultMiss        = "miss"
+	cacheResultNegativeHit = "negative_hit"
+	cacheResultError       = "error"
+
+	cacheLoadSuccess  = "success"
+	cacheLoadNotFound = "not_found"
+	cacheLoadError    = "error"
+)
+
+// LoadFunc loads the value from the source of truth when cache miss.
+//
+// Returns an error that errors.Is(err, qerror.ResourceNotExists) if the value not exists,
+// the result will be cached by negative ttl.
+type LoadFunc func(ctx context.Context) (value interface{}, err error)
+
+// CacheOption used to change the behavior of Cache.
+type CacheOption func(o *cacheOptions)
+
+type cacheOptions struct {
+	codec       Codec
+	jitter      time.Duration
+	negativeTTL time.Duration
+	loadTimeout time.Duration
+}
+
+// WithCacheCodec sets the Codec to serialize values. Defaults JSONCodec.
+func WithCacheCodec(codec Codec) CacheOption {
+	return func(o *cacheOptions) {
+		o.codec = codec
+	}
+}
+
+// WithCacheJitter sets the max random duration that adds to the ttl of every key,
+// to avoid a lot of keys expired at the same time. Defaults 0.
+func WithCacheJitter(d time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.jitter = d
+	}
+}
+
+// WithCacheNegativeTTL sets the ttl to cache the not found results. 0 means disabled. Defaults 30s.
+func WithCacheNegativeTTL(d time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.negativeTTL = d
+	}
+}
+
+// WithCacheLoadTimeout sets the timeout of loader. The loader is shared by the concurrent callers
+// of the same key, so it's not canceled by the ctx of any caller but by the timeout. Defaults 10s.
+func WithCacheLoadTimeout(d time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.loadTimeout = d
+	}
+}
+
+// Cache implements a cache-aside layer on redis.
+//
+// All keys are prefixed with "cache:<name>:". Concurrent loads of the same key in a process
+// are merged into one call to avoid cache stampede.
+type Cache struct {
+	// The 64-bit counters must be at the beginning of the struct to guarantee alignment.
+	hits         uint64
+	misses       uint64
+	negativeHits uint64
+	errors       uint64
+
+	client Client
+	name   string
+	prefix string
+	opts   cacheOptions
+	group  singleflight.Group
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// CacheStats is the lookup statistics of Cache.
+type CacheStats struct {
+	Hits         uint64
+	Misses       uint64
+	NegativeHits uint64
+	Errors       uint64
+}
+
+// NewCache creates a Cache, the name is used to prefix keys and label metrics.
+func NewCache(client Client, name string, opts ...CacheOption) *Cache {
+	c := &Cache{
+		client: client,
+		name:   name,
+		prefix: "cache:" + name + ":",
+		opts: cacheOptions{
+			codec:       JSONCodec{},
+			jitter:      0,
+			negativeTTL: time.Second * 30,
+			loadTimeout: time.Second * 10,
+		},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	return c
+}
+
+// Name returns the cache name.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Get gets the value of key and decodes it into dst.
+//
+// Returns ErrCacheMiss if key not exists, or a qerror.ResourceNotExists if the key was negative cached.
+func (c *Cache) Get(ctx context.Context, key string, dst interface{}) error {
+	data, err := c.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.decode(key, data, dst)
+}
+
+// getBytes gets the raw value of key and records the lookup result.
+func (c *Cache) getBytes(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	switch {
+	case err == redis.Nil:
+		c.record(cacheResultMiss)
+		return nil, ErrCacheMiss
+	case err != nil:
+		c.record(cacheResultError)
+		return nil, err
+	case len(data) != 0 && data[0] == cacheFlagNotFound:
+		c.record(cacheResultNegativeHit)
+	default:
+		c.record(cacheResultHit)
+	}
+	return data, nil
+}
+
+// Stats returns the lookup statistics since the Cache created.
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:         atomic.LoadUint64(&c.hits),
+		Misses:       atomic.LoadUint64(&c.misses),
+		NegativeHits: atomic.LoadUint64(&c.negativeHits),
+		Errors:       atomic.LoadUint64(&c.errors),
+	}
+}
+
+func (c *Cache) record(result string) {
+	switch result {
+	case cacheResultHit:
+		atomic.AddUint64(&c.hits, 1)
+	case cacheResultMiss:
+		atomic.AddUint64(&c.misses, 1)
+	case cacheResultNegativeHit:
+		atomic.AddUint64(&c.negativeHits, 1)
+	case cacheResultError:
+		atomic.AddUint64(&c.errors, 1)
+	}
+	cacheRequestsCounter.WithLabelValues(c.name, result).Inc()
+}
+
+// Set encodes the value and stores it with ttl. The ttl will be added a random jitter.
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.prefix+key, data, c.jitterTTL(ttl)).Err()
+}
+
+// Delete removes the keys from cache.
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	fullKeys := make([]string, len(keys))
+	for i := range keys {
+		fullKeys[i] = c.prefix + keys[i]
+	}
+	// Delete one by one to avoid CROSSSLOT error in cluster mode.
+	pipe := c.client.Pipeline()
+	for i := range fullKeys {
+		pipe.Del(ctx, fullKeys[i])
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetOrLoad gets the value of key and decodes it into dst. If the key not exists, the loader
+// will be called and the result will be stored with ttl.
+//
+// The redis errors are logged and ignored, the loader will be called as cache miss.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, dst interface{}, loader LoadFunc) error {
+	data, err := c.getOrLoadBytes(ctx, key, ttl, loader)
+	if err != nil {
+		return err
+	}
+	return c.decode(key, data, dst)
+}
+
+// getOrLoadBytes returns the raw value of key, calls the loader if the key not exists.
+//
+// If the loader returns not found, the negative value is returned with the loader's error.
+func (c *Cache) getOrLoadBytes(ctx context.Context, key string, ttl time.Duration, loader LoadFunc) ([]byte, error) {
+	data, err := c.getBytes(ctx, key)
+	if err == nil {
+		return data, nil
+	}
+	if err != ErrCacheMiss {
+		glog.FromContext(ctx).Warn().Msg("redis: cache get error, load from source").
+			String("cache", c.name).String("key", key).Error("error", err).Fire()
+	}
+
+	// The loader is detached from the ctx of the first caller, so the other callers waiting
+	// for it are not failed by the canceling of the first one.
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(detachedContext{parent: ctx}, c.opts.loadTimeout)
+		defer cancel()
+		return c.load(loadCtx, key, ttl, loader)
+	})
+	select {
+	case result := <-ch:
+		data, _ = result.Val.([]byte)
+		return data, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detachedContext keeps the values of parent such as logger and trace id, but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
+
+// load calls the loader and stores the encoded result in redis.
+func (c *Cache) load(ctx context.Context, key string, ttl time.Duration, loader LoadFunc) ([]byte, error) {
+	start := time.Now()
+	value, err := loader(ctx)
+	elapsed := time.Since(start).Seconds()
+
+	if err != nil {
+		if !errors.Is(err, qerror.ResourceNotExists) {
+			cacheLoadDurationHistogram.WithLabelValues(c.name, cacheLoadError).Observe(elapsed)
+			return nil, err
+		}
+		cacheLoadDurationHistogram.WithLabelValues(c.name, cacheLoadNotFound).Observe(elapsed)
+		if c.opts.negativeTTL <= 0 {
+			return nil, err
+		}
+		data := []byte{cacheFlagNotFound}
+		if e := c.client.Set(ctx, c.prefix+key, data, c.opts.negativeTTL).Err(); e != nil {
+			glog.FromContext(ctx).Warn().Msg("redis: cache set negative error").
+				String("cache", c.name).String("key", key).Error("error", e).Fire()
+		}
+		return data, err
+	}
+	cacheLoadDurationHistogram.WithLabelValues(c.name, cacheLoadSuccess).Observe(elapsed)
+
+	data, err := c.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	if e := c.client.Set(ctx, c.prefix+key, data, c.jitterTTL(ttl)).Err(); e != nil {
+		glog.FromContext(ctx).Warn().Msg("redis: cache set error").
+			String("cache", c.name).String("key", key).Error("error", e).Fire()
+	}
+	return data, nil
+}
+
+func (c *Cache) encode(value interface{}) ([]byte, error) {
+	b, err := c.opts.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(b)+1)
+	data[0] = cacheFlagValue
+	copy(data[1:], b)
+	return data, nil
+}
+
+func (c *Cache) decode(key string, data []byte, dst interface{}) error {
+	if len(data) == 0 {
+		return errors.New("rediswrap: invalid cache value")
+	}
+	switch data[0] {
+	case cacheFlagNotFound:
+		return qerror.ResourceNotExists.Format(key)
+	case cacheFlagValue:
+		return c.opts.codec.Unmarshal(data[1:], dst)
+	default:
+		return errors.New("rediswrap: invalid cache value")
+	}
+}
+
+func (c *Cache) jitterTTL(ttl time.Duration) time.Duration {
+	if c.opts.jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	c.randMu.Lock()
+	n := c.rand.Int63n(int64(c.opts.jitter))
+	c.randMu.Unlock()
+	return ttl + time.Duration(n)
+}
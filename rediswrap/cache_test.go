@@ -0,0 +1,163 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+type testUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	mr, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	c := NewCache(rdb, "user", WithCacheJitter(time.Second*10))
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return &testUser{ID: "usr-1", Name: "foo"}, nil
+	}
+
+	var u1 testUser
+	require.Nil(t, c.GetOrLoad(ctx, "usr-1", time.Minute, &u1, loader))
+	require.Equal(t, "foo", u1.Name)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	ttl := mr.TTL("cache:user:usr-1")
+	require.GreaterOrEqual(t, int64(ttl), int64(time.Minute))
+	require.Less(t, int64(ttl), int64(time.Minute+time.Second*10))
+
+	// Hits the cache.
+	var u2 testUser
+	require.Nil(t, c.GetOrLoad(ctx, "usr-1", time.Minute, &u2, loader))
+	require.Equal(t, u1, u2)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Load again after deleted.
+	require.Nil(t, c.Delete(ctx, "usr-1"))
+	require.Equal(t, ErrCacheMiss, c.Get(ctx, "usr-1", &u2))
+	require.Nil(t, c.GetOrLoad(ctx, "usr-1", time.Minute, &u2, loader))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCache_GetOrLoad_Singleflight(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	c := NewCache(rdb, "user")
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 100)
+		return &testUser{ID: "usr-1", Name: "foo"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var u testUser
+			require.Nil(t, c.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader))
+			require.Equal(t, "foo", u.Name)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCache_GetOrLoad_CallerCanceled(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	c := NewCache(rdb, "user")
+
+	var calls int32
+	var once sync.Once
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		once.Do(func() { close(started) })
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &testUser{ID: "usr-1", Name: "foo"}, nil
+	}
+
+	// The first caller starts the load and is canceled.
+	firstCtx, cancel := context.WithCancel(ctx)
+	firstErr := make(chan error, 1)
+	go func() {
+		var u testUser
+		firstErr <- c.GetOrLoad(firstCtx, "usr-1", time.Minute, &u, loader)
+	}()
+	<-started
+
+	secondErr := make(chan error, 1)
+	var u testUser
+	go func() {
+		secondErr <- c.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader)
+	}()
+	// Waits for the second caller to join the load.
+	time.Sleep(time.Millisecond * 50)
+
+	cancel()
+	require.Equal(t, context.Canceled, <-firstErr)
+	close(release)
+	require.Nil(t, <-secondErr)
+	require.Equal(t, "foo", u.Name)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCache_GetOrLoad_Negative(t *testing.T) {
+	mr, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	c := NewCache(rdb, "user", WithCacheNegativeTTL(time.Second*30))
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, qerror.ResourceNotExists.Format("usr-1")
+	}
+
+	var u testUser
+	err := c.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader)
+	require.True(t, errors.Is(err, qerror.ResourceNotExists))
+	err = c.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader)
+	require.True(t, errors.Is(err, qerror.ResourceNotExists))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Equal(t, time.Second*30, mr.TTL("cache:user:usr-1"))
+
+	// The negative result expired.
+	mr.FastForward(time.Second * 31)
+	_ = c.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Other errors are not cached.
+	failed := errors.New("failed")
+	c = NewCache(rdb, "key")
+	for i := 0; i < 2; i++ {
+		err = c.GetOrLoad(ctx, "k", time.Minute, &u, func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, failed
+		})
+		require.Equal(t, failed, err)
+	}
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
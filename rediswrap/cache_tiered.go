@@ -0,0 +1,263 @@
+package rediswrap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+)
+
+// TieredCacheOption used to change the behavior of TieredCache.
+type TieredCacheOption func(o *tieredCacheOptions)
+
+type tieredCacheOptions struct {
+	localSize int
+	localTTL  time.Duration
+	channel   string
+}
+
+// WithLocalSize sets the max number of keys in the local cache. Defaults 10000.
+func WithLocalSize(n int) TieredCacheOption {
+	return func(o *tieredCacheOptions) {
+		o.localSize = n
+	}
+}
+
+// WithLocalTTL sets the max ttl of keys in the local cache. Defaults 1m.
+//
+// It's the upper bound of stale time if an invalidation message is lost.
+func WithLocalTTL(d time.Duration) TieredCacheOption {
+	return func(o *tieredCacheOptions) {
+		o.localTTL = d
+	}
+}
+
+// WithInvalidationChannel sets the redis channel to publish and receive the invalidation messages.
+// Defaults "cache:invalidate:<name>".
+func WithInvalidationChannel(channel string) TieredCacheOption {
+	return func(o *tieredCacheOptions) {
+		o.channel = channel
+	}
+}
+
+// Subscriber subscribes to the redis channels. Both *redis.Client and *redis.ClusterClient
+// implement it.
+type Subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// TieredCacheStats is the statistics of both tiers of TieredCache.
+type TieredCacheStats struct {
+	LocalHits      uint64
+	LocalMisses    uint64
+	LocalEvictions uint64
+	LocalSize      int
+	Remote         CacheStats
+}
+
+// TieredCache is an in-process LRU cache in front of the redis Cache.
+//
+// The local caches of all replicas are kept coherent by publishing invalidation messages on a
+// redis channel when a key is written or deleted through Set or Delete. Keys that are written to
+// redis by others (or messages lost during reconnection) may be stale at most the local ttl.
+type TieredCache struct {
+	remote *Cache
+	local  *localCache
+	opts   tieredCacheOptions
+	origin string
+	pubsub *redis.PubSub
+	lp     *glog.Logger
+	cancel context.CancelFunc
+	exitC  chan struct{}
+}
+
+// NewTieredCache creates a TieredCache on the redis Cache and subscribes the invalidation channel
+// by the subscriber, it's usually the same client used by the Cache. Call Close to stop the subscription.
+func NewTieredCache(ctx context.Context, remote *Cache, subscriber Subscriber, opts ...TieredCacheOption) (*TieredCache, error) {
+	c := &TieredCache{
+		remote: remote,
+		opts: tieredCacheOptions{
+			localSize: 10000,
+			localTTL:  time.Minute,
+			channel:   "cache:invalidate:" + remote.Name(),
+		},
+		lp:    glog.FromContext(ctx),
+		exitC: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	c.local = newLocalCache(c.opts.localSize)
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	c.origin = hex.EncodeToString(b)
+
+	// Wait for the subscription confirmed to make sure no invalidation will be missed.
+	c.pubsub = subscriber.Subscribe(ctx, c.opts.channel)
+	if _, err := c.pubsub.Receive(ctx); err != nil {
+		_ = c.pubsub.Close()
+		return nil, err
+	}
+
+	var ctxSub context.Context
+	ctxSub, c.cancel = context.WithCancel(context.Background())
+	go c.receiveLoop(ctxSub)
+	return c, nil
+}
+
+// Get gets the value of key from the local cache first, then from redis.
+func (c *TieredCache) Get(ctx context.Context, key string, dst interface{}) error {
+	if data, ok := c.local.get(key); ok {
+		c.recordLocal(cacheResultHit)
+		return c.remote.decode(key, data, dst)
+	}
+	c.recordLocal(cacheResultMiss)
+
+	data, err := c.remote.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	// The ttl in redis is unknown, keeps the positive value for the max local ttl.
+	c.setLocal(key, data, 0)
+	return c.remote.decode(key, data, dst)
+}
+
+// GetOrLoad gets the value of key from the local cache first, then from redis and the loader.
+func (c *TieredCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, dst interface{}, loader LoadFunc) error {
+	if data, ok := c.local.get(key); ok {
+		c.recordLocal(cacheResultHit)
+		return c.remote.decode(key, data, dst)
+	}
+	c.recordLocal(cacheResultMiss)
+
+	data, err := c.remote.getOrLoadBytes(ctx, key, ttl, loader)
+	if len(data) > 0 {
+		c.setLocal(key, data, ttl)
+	}
+	if err != nil {
+		return err
+	}
+	return c.remote.decode(key, data, dst)
+}
+
+// Set stores the value in redis and the local cache, and invalidates the key in other replicas.
+func (c *TieredCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := c.remote.encode(value)
+	if err != nil {
+		return err
+	}
+	if err = c.remote.client.Set(ctx, c.remote.prefix+key, data, c.remote.jitterTTL(ttl)).Err(); err != nil {
+		c.local.delete(key)
+		return err
+	}
+	c.local.set(key, data, c.localTTL(ttl))
+	return c.publish(ctx, key)
+}
+
+// Delete removes the keys from redis and local cache, and invalidates the keys in other replicas.
+func (c *TieredCache) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.local.delete(key)
+	}
+	if err := c.remote.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return c.publish(ctx, keys...)
+}
+
+// Stats returns the statistics of both tiers.
+func (c *TieredCache) Stats() TieredCacheStats {
+	hits, misses, evictions, size := c.local.stats()
+	return TieredCacheStats{
+		LocalHits:      hits,
+		LocalMisses:    misses,
+		LocalEvictions: evictions,
+		LocalSize:      size,
+		Remote:         c.remote.Stats(),
+	}
+}
+
+// Close stops the subscription of invalidation channel.
+func (c *TieredCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.cancel()
+	err := c.pubsub.Close()
+	<-c.exitC
+	return err
+}
+
+// publish sends the invalidation message in format "<origin> <key>".
+func (c *TieredCache) publish(ctx context.Context, keys ...string) error {
+	pipe := c.remote.client.Pipeline()
+	for _, key := range keys {
+		pipe.Publish(ctx, c.opts.channel, c.origin+" "+key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// receiveLoop receives the invalidation messages until ctx canceled.
+func (c *TieredCache) receiveLoop(ctx context.Context) {
+	defer close(c.exitC)
+
+	for {
+		msg, err := c.pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The PubSub reconnects and resubscribes automatically,
+			// purge the local cache because messages may have been lost.
+			c.lp.Warn().Msg("redis: receive cache invalidation error, purge local cache").
+				String("channel", c.opts.channel).Error("error", err).Fire()
+			c.local.purge()
+			select {
+			case <-time.After(time.Millisecond * 100):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Message:
+			i := strings.IndexByte(m.Payload, ' ')
+			if i < 0 || m.Payload[:i] == c.origin {
+				continue
+			}
+			c.local.delete(m.Payload[i+1:])
+			cacheLocalInvalidationsCounter.WithLabelValues(c.remote.name).Inc()
+		case *redis.Subscription:
+			// Resubscribed after reconnection.
+			c.local.purge()
+		}
+	}
+}
+
+// setLocal stores the data in local cache, the negative value is kept for the negative ttl.
+func (c *TieredCache) setLocal(key string, data []byte, ttl time.Duration) {
+	if len(data) > 0 && data[0] == cacheFlagNotFound {
+		ttl = c.remote.opts.negativeTTL
+	}
+	c.local.set(key, data, c.localTTL(ttl))
+}
+
+func (c *TieredCache) localTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > c.opts.localTTL {
+		return c.opts.localTTL
+	}
+	return ttl
+}
+
+func (c *TieredCache) recordLocal(result string) {
+	cacheLocalRequestsCounter.WithLabelValues(c.remote.name, result).Inc()
+}
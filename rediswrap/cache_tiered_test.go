@@ -0,0 +1,115 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func TestLocalCache(t *testing.T) {
+	c := newLocalCache(2)
+
+	c.set("a", []byte("1"), time.Minute)
+	c.set("b", []byte("2"), time.Minute)
+	_, ok := c.get("a")
+	require.True(t, ok)
+
+	// "b" is the least recently used.
+	c.set("c", []byte("3"), time.Minute)
+	_, ok = c.get("b")
+	require.False(t, ok)
+	_, ok = c.get("a")
+	require.True(t, ok)
+
+	c.set("d", []byte("4"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	_, ok = c.get("d")
+	require.False(t, ok)
+
+	hits, misses, evictions, size := c.stats()
+	require.Equal(t, uint64(2), hits)
+	require.Equal(t, uint64(2), misses)
+	require.Equal(t, uint64(2), evictions)
+	require.Equal(t, 1, size)
+}
+
+func TestTieredCache(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	// Two replicas share the same redis.
+	c1, err := NewTieredCache(ctx, NewCache(rdb, "user"), rdb.(Subscriber))
+	require.Nil(t, err)
+	defer func() { _ = c1.Close() }()
+	c2, err := NewTieredCache(ctx, NewCache(rdb, "user"), rdb.(Subscriber))
+	require.Nil(t, err)
+	defer func() { _ = c2.Close() }()
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return &testUser{ID: "usr-1", Name: "foo"}, nil
+	}
+
+	var u testUser
+	require.Nil(t, c1.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader))
+	require.Nil(t, c2.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader))
+	require.Nil(t, c2.GetOrLoad(ctx, "usr-1", time.Minute, &u, loader))
+	require.Equal(t, "foo", u.Name)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	stats := c2.Stats()
+	require.Equal(t, uint64(1), stats.LocalHits)
+	require.Equal(t, uint64(1), stats.LocalMisses)
+	require.Equal(t, uint64(1), stats.Remote.Hits)
+	require.Equal(t, 1, stats.LocalSize)
+
+	// Writes in c1 invalidate the local cache of c2.
+	require.Nil(t, c1.Set(ctx, "usr-1", &testUser{ID: "usr-1", Name: "bar"}, time.Minute))
+	require.Eventually(t, func() bool {
+		var u testUser
+		require.Nil(t, c2.Get(ctx, "usr-1", &u))
+		return u.Name == "bar"
+	}, time.Second*5, time.Millisecond*10)
+
+	// The writer's own local cache is updated.
+	require.Nil(t, c1.Get(ctx, "usr-1", &u))
+	require.Equal(t, "bar", u.Name)
+
+	require.Nil(t, c1.Delete(ctx, "usr-1"))
+	require.Eventually(t, func() bool {
+		return c2.Get(ctx, "usr-1", &u) == ErrCacheMiss
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestTieredCacheLocalTTL(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	negativeTTL := time.Millisecond * 50
+	remote := NewCache(rdb, "user", WithCacheNegativeTTL(negativeTTL))
+	c, err := NewTieredCache(ctx, remote, rdb.(Subscriber))
+	require.Nil(t, err)
+	defer func() { _ = c.Close() }()
+
+	require.Nil(t, remote.Set(ctx, "usr-1", &testUser{ID: "usr-1", Name: "foo"}, time.Minute))
+	loader := func(ctx context.Context) (interface{}, error) {
+		return nil, qerror.ResourceNotExists.Format("usr-2")
+	}
+	var u testUser
+	require.Nil(t, c.Get(ctx, "usr-1", &u))
+	require.True(t, errors.Is(c.GetOrLoad(ctx, "usr-2", time.Minute, &u, loader), qerror.ResourceNotExists))
+
+	// The positive value is kept by the local ttl, the negative one by the negative ttl.
+	time.Sleep(negativeTTL * 2)
+	_, ok := c.local.get("usr-1")
+	require.True(t, ok)
+	_, ok = c.local.get("usr-2")
+	require.False(t, ok)
+}
@@ -2,10 +2,14 @@ package rediswrap
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/tlsutil"
+	"github.com/DataWorkbench/glog"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -27,42 +31,178 @@ type RedisConfig struct {
 	MasterName string `json:"master_name"         yaml:"master_name"     env:"MASTER_NAME"`
 	// eg: "127.0.0.1:6379".
 	StandaloneAddr string `json:"standalone_addr" yaml:"standalone_addr" env:"STANDALONE_ADDR"`
-	// eg: "127.0.0.1:26379,127.0.0.1:26380,127.0.0.1:26381"
-	ClusterAddr string `json:"cluster_addr"       yaml:"cluster_addr"    env:"CLUSTER_ADDR"`
 	// eg: "127.0.0.1:7000,127.0.0.1:7001,127.0.0.1:7002,127.0.0.1:7003,127.0.0.1:7004,127.0.0.1:7005".
+	ClusterAddr string `json:"cluster_addr"       yaml:"cluster_addr"    env:"CLUSTER_ADDR"`
+	// eg: "127.0.0.1:26379,127.0.0.1:26380,127.0.0.1:26381"
 	SentinelAddr string `json:"sentinel_addr"     yaml:"sentinel_addr"   env:"SENTINEL_ADDR"`
-	UserName     string `json:"user_name"         yaml:"user_name"       env:"UER_NAME"`
+	UserName     string `json:"user_name"         yaml:"user_name"       env:"USER_NAME"`
 	Password     string `json:"password"          yaml:"password"        env:"PASSWORD"`
-	Database     int    `json:"database"          yaml:"database"        env:"DATABASE"`
+	// Database is selected in standalone and sentinel mode. Must be 0 in cluster mode.
+	Database int `json:"database"          yaml:"database"        env:"DATABASE"`
+	// Name identifies the client in metrics, sets it if there are multiple clients in a service.
+	Name string `json:"name" yaml:"name" env:"NAME,default=default"`
+	// SlowThreshold time 0 indicates disabled
+	SlowThreshold time.Duration `json:"slow_threshold" yaml:"slow_threshold" env:"SLOW_THRESHOLD,default=100ms" validate:"gte=0"`
+
+	// PoolSize is the max number of connections of each node. 0 means 10 * runtime.GOMAXPROCS.
+	PoolSize     int `json:"pool_size"      yaml:"pool_size"      env:"POOL_SIZE"      validate:"gte=0"`
+	MinIdleConns int `json:"min_idle_conns" yaml:"min_idle_conns" env:"MIN_IDLE_CONNS" validate:"gte=0"`
+	// MaxRetries is the max retries before giving up. -1 disables retries.
+	MaxRetries   int           `json:"max_retries"   yaml:"max_retries"   env:"MAX_RETRIES,default=3"   validate:"gte=-1"`
+	DialTimeout  time.Duration `json:"dial_timeout"  yaml:"dial_timeout"  env:"DIAL_TIMEOUT,default=5s"  validate:"gte=0"`
+	ReadTimeout  time.Duration `json:"read_timeout"  yaml:"read_timeout"  env:"READ_TIMEOUT,default=3s"  validate:"gte=-1"`
+	WriteTimeout time.Duration `json:"write_timeout" yaml:"write_timeout" env:"WRITE_TIMEOUT,default=3s" validate:"gte=-1"`
+	// PoolTimeout is the time to wait for a free connection. 0 means ReadTimeout + 1s.
+	PoolTimeout time.Duration `json:"pool_timeout" yaml:"pool_timeout" env:"POOL_TIMEOUT" validate:"gte=0"`
+	// IdleTimeout is the time after which idle connections are closed. -1 disables it.
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout" env:"IDLE_TIMEOUT,default=5m" validate:"gte=-1"`
+
+	// TLSEnabled controls whether connects to server with TLS.
+	TLSEnabled bool `json:"tls_enabled" yaml:"tls_enabled" env:"TLS_ENABLED,default=false"`
+	// TLSCAFile is used to verify the server certificate. Uses the system root CAs if empty.
+	TLSCAFile string `json:"tls_ca_file" yaml:"tls_ca_file" env:"TLS_CA_FILE"`
+	// The client key pair for mTLS. Both are optional.
+	TLSCertFile string `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE" validate:"required_with=TLSKeyFile"`
+	TLSKeyFile  string `json:"tls_key_file"  yaml:"tls_key_file"  env:"TLS_KEY_FILE"  validate:"required_with=TLSCertFile"`
+	// TLSServerName overrides the server name used to verify the server certificate.
+	TLSServerName string `json:"tls_server_name" yaml:"tls_server_name" env:"TLS_SERVER_NAME"`
+	// TLSInsecureSkipVerify disables verification of server certificate. Only used for testing.
+	TLSInsecureSkipVerify bool `json:"tls_insecure_skip_verify" yaml:"tls_insecure_skip_verify" env:"TLS_INSECURE_SKIP_VERIFY,default=false"`
+}
+
+// validate checks the fields required by the mode.
+func (cfg *RedisConfig) validate() error {
+	switch cfg.Mode {
+	case StandaloneMode:
+		if cfg.StandaloneAddr == "" {
+			return fmt.Errorf("standalone_addr is required in %s mode", cfg.Mode)
+		}
+	case SentinelMode:
+		if cfg.SentinelAddr == "" {
+			return fmt.Errorf("sentinel_addr is required in %s mode", cfg.Mode)
+		}
+		if cfg.MasterName == "" {
+			return fmt.Errorf("master_name is required in %s mode", cfg.Mode)
+		}
+	case ClusterMode:
+		if cfg.ClusterAddr == "" {
+			return fmt.Errorf("cluster_addr is required in %s mode", cfg.Mode)
+		}
+		if cfg.Database != 0 {
+			return fmt.Errorf("database %d is not allowed in %s mode", cfg.Database, cfg.Mode)
+		}
+	default:
+		return fmt.Errorf("unsupported mode: %s", cfg.Mode)
+	}
+	return nil
 }
 
+// NewRedisConn returns a redis client with trace, metrics and slow log hooks.
+// It pings the server before returns. The returned Client is a *redis.Client, *redis.ClusterClient
+// or a failover *redis.Client according to the mode. Close it by CloseRedisConn to stop collecting
+// the pool stats of it.
+// NOTICE: Must set glog.Logger into the ctx by glog.WithContext
 func NewRedisConn(ctx context.Context, cfg *RedisConfig) (Client, error) {
+	lg := glog.FromContext(ctx)
+
+	if err := cfg.validate(); err != nil {
+		lg.Error().Error("redis: invalid config", err).Fire()
+		return nil, err
+	}
+
+	var tlsCfg *tls.Config
+	if cfg.TLSEnabled {
+		var err error
+		tlsCfg, err = tlsutil.NewClientConfig(&tlsutil.Options{
+			CAFile:             cfg.TLSCAFile,
+			CertFile:           cfg.TLSCertFile,
+			KeyFile:            cfg.TLSKeyFile,
+			ServerName:         cfg.TLSServerName,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		})
+		if err != nil {
+			lg.Error().Error("redis: load tls config error", err).Fire()
+			return nil, err
+		}
+	}
+
 	var rdb Client
 	switch cfg.Mode {
 	case StandaloneMode:
 		rdb = redis.NewClient(&redis.Options{
-			Addr:     cfg.StandaloneAddr,
-			Username: cfg.UserName,
-			Password: cfg.Password,
+			Addr:         cfg.StandaloneAddr,
+			Username:     cfg.UserName,
+			Password:     cfg.Password,
+			DB:           cfg.Database,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+			TLSConfig:    tlsCfg,
 		})
 	case SentinelMode:
 		rdb = redis.NewFailoverClusterClient(&redis.FailoverOptions{
 			MasterName:    cfg.MasterName,
-			Username:      cfg.UserName,
 			SentinelAddrs: strings.Split(cfg.SentinelAddr, ","),
+			Username:      cfg.UserName,
 			Password:      cfg.Password,
 			DB:            cfg.Database,
+			MaxRetries:    cfg.MaxRetries,
+			DialTimeout:   cfg.DialTimeout,
+			ReadTimeout:   cfg.ReadTimeout,
+			WriteTimeout:  cfg.WriteTimeout,
+			PoolSize:      cfg.PoolSize,
+			MinIdleConns:  cfg.MinIdleConns,
+			PoolTimeout:   cfg.PoolTimeout,
+			IdleTimeout:   cfg.IdleTimeout,
+			TLSConfig:     tlsCfg,
 		})
 	case ClusterMode:
 		rdb = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    strings.Split(cfg.ClusterAddr, ","),
-			Username: cfg.UserName,
-			Password: cfg.Password,
+			Addrs:        strings.Split(cfg.ClusterAddr, ","),
+			Username:     cfg.UserName,
+			Password:     cfg.Password,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+			TLSConfig:    tlsCfg,
 		})
-	default:
-		return nil, fmt.Errorf("unsupported mode: %s", cfg.Mode)
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = "default"
 	}
 
 	rdb.AddHook(&hookTrace{tracer: gtrace.TracerFromContext(ctx)})
+	rdb.AddHook(&hookMetrics{name: name})
+	if cfg.SlowThreshold > 0 {
+		rdb.AddHook(&hookSlowLog{output: lg, threshold: cfg.SlowThreshold})
+	}
+
+	lg.Debug().Msg("redis: connecting to server").String("mode", cfg.Mode).String("name", name).Bool("tls", cfg.TLSEnabled).Fire()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		lg.Error().Error("redis: ping server error", err).Fire()
+		_ = rdb.Close()
+		return nil, err
+	}
+	lg.Debug().Msg("redis: successful connection to server").Fire()
+
+	poolStats.add(name, rdb)
 	return rdb, nil
 }
+
+// CloseRedisConn stops collecting the pool stats of client and closes it.
+func CloseRedisConn(client Client) error {
+	poolStats.remove(client)
+	return client.Close()
+}
@@ -0,0 +1,172 @@
+package rediswrap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisConnMetrics(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := newTestContext()
+
+	client, err := NewRedisConn(ctx, &RedisConfig{
+		Mode:           StandaloneMode,
+		StandaloneAddr: mr.Addr(),
+		Name:           "metrics-test",
+		SlowThreshold:  time.Nanosecond,
+	})
+	require.Nil(t, err)
+	defer func() { _ = CloseRedisConn(client) }()
+	// The client of go-redis is returned as is.
+	_, ok := client.(*redis.Client)
+	require.True(t, ok)
+
+	require.Nil(t, client.Set(ctx, "k", "v", 0).Err())
+	require.Equal(t, redis.Nil, client.Get(ctx, "missing").Err())
+	require.NotNil(t, client.Incr(ctx, "k").Err())
+
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "k")
+		pipe.Incr(ctx, "k")
+		return nil
+	})
+	require.NotNil(t, err)
+
+	// The redis.Nil is not an error.
+	require.Equal(t, float64(0), testutil.ToFloat64(commandErrorsCounter.WithLabelValues("metrics-test", "get")))
+	require.Equal(t, float64(2), testutil.ToFloat64(commandErrorsCounter.WithLabelValues("metrics-test", "incr")))
+	for _, cmd := range []string{"ping", "set", "get", "incr", "pipeline"} {
+		m := &dto.Metric{}
+		require.Nil(t, commandDurationHistogram.WithLabelValues("metrics-test", cmd).(prometheus.Metric).Write(m))
+		require.Equal(t, uint64(1), m.GetHistogram().GetSampleCount(), cmd)
+	}
+
+	require.GreaterOrEqual(t, testutil.CollectAndCount(poolStats), 6)
+}
+
+func TestNewRedisConnValidate(t *testing.T) {
+	ctx := newTestContext()
+
+	cases := []*RedisConfig{
+		{Mode: "unknown"},
+		{Mode: StandaloneMode},
+		{Mode: SentinelMode, MasterName: "master"},
+		{Mode: SentinelMode, SentinelAddr: "127.0.0.1:26379"},
+		{Mode: ClusterMode},
+		{Mode: ClusterMode, ClusterAddr: "127.0.0.1:7000", Database: 1},
+	}
+	for _, cfg := range cases {
+		_, err := NewRedisConn(ctx, cfg)
+		require.NotNil(t, err, cfg)
+	}
+}
+
+func TestNewRedisConnDatabase(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := newTestContext()
+
+	client, err := NewRedisConn(ctx, &RedisConfig{
+		Mode:           StandaloneMode,
+		StandaloneAddr: mr.Addr(),
+		Name:           "database-test",
+		Database:       2,
+		PoolSize:       4,
+		MinIdleConns:   1,
+		DialTimeout:    time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   time.Second,
+	})
+	require.Nil(t, err)
+	defer func() { _ = CloseRedisConn(client) }()
+
+	require.Nil(t, client.Set(ctx, "k", "v", 0).Err())
+	v, err := mr.DB(2).Get("k")
+	require.Nil(t, err)
+	require.Equal(t, "v", v)
+	require.False(t, mr.Exists("k"))
+}
+
+func TestNewRedisConnPing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	_, err := NewRedisConn(newTestContext(), &RedisConfig{
+		Mode:           StandaloneMode,
+		StandaloneAddr: addr,
+		MaxRetries:     -1,
+		DialTimeout:    time.Millisecond * 100,
+	})
+	require.NotNil(t, err)
+}
+
+// poolStatsOf returns the metrics of poolStats labeled by name.
+func poolStatsOf(t *testing.T, name string) []*dto.Metric {
+	ch := make(chan prometheus.Metric, 64)
+	poolStats.Collect(ch)
+	close(ch)
+	var metrics []*dto.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		require.Nil(t, m.Write(pb))
+		for _, label := range pb.GetLabel() {
+			if label.GetName() == "name" && label.GetValue() == name {
+				metrics = append(metrics, pb)
+			}
+		}
+	}
+	return metrics
+}
+
+func TestNewRedisConnPoolStats(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := newTestContext()
+
+	cfg := &RedisConfig{Mode: StandaloneMode, StandaloneAddr: mr.Addr(), Name: "pool-stats-test"}
+	c1, err := NewRedisConn(ctx, cfg)
+	require.Nil(t, err)
+	c2, err := NewRedisConn(ctx, cfg)
+	require.Nil(t, err)
+
+	// The clients with the same name are summed.
+	metrics := poolStatsOf(t, "pool-stats-test")
+	require.Len(t, metrics, 6)
+	// Each client keeps the connection of ping in pool.
+	var conns float64
+	for _, m := range metrics {
+		if g := m.GetGauge(); g != nil && g.GetValue() > conns {
+			conns = g.GetValue()
+		}
+	}
+	require.Equal(t, float64(2), conns)
+
+	counters := func() (total float64, conns float64) {
+		for _, m := range poolStatsOf(t, "pool-stats-test") {
+			if c := m.GetCounter(); c != nil {
+				total += c.GetValue()
+			}
+			if g := m.GetGauge(); g != nil {
+				conns += g.GetValue()
+			}
+		}
+		return
+	}
+	before, _ := counters()
+	require.Greater(t, before, float64(0))
+
+	// The counters of closed clients are kept, and the gauges drop.
+	require.Nil(t, CloseRedisConn(c1))
+	require.Len(t, poolStatsOf(t, "pool-stats-test"), 6)
+	require.Nil(t, CloseRedisConn(c2))
+	require.Len(t, poolStatsOf(t, "pool-stats-test"), 6)
+	after, conns := counters()
+	require.GreaterOrEqual(t, after, before)
+	require.Equal(t, float64(0), conns)
+}
@@ -0,0 +1,22 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestClient starts an in-memory redis server and returns a client connected to it.
+func newTestClient(t *testing.T) (*miniredis.Miniredis, Client) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+func newTestContext() context.Context {
+	return glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+}
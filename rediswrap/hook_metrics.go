@@ -0,0 +1,56 @@
+package rediswrap
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type hookMetricsStartKey struct{}
+
+// hookMetrics implements redis.Hook to collect the latency and errors of commands.
+type hookMetrics struct {
+	name string
+}
+
+func (h *hookMetrics) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookMetricsStartKey{}, time.Now()), nil
+}
+
+func (h *hookMetrics) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	start, ok := ctx.Value(hookMetricsStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	name := strings.ToLower(cmd.Name())
+	commandDurationHistogram.WithLabelValues(h.name, name).Observe(time.Since(start).Seconds())
+	if isCommandError(cmd.Err()) {
+		commandErrorsCounter.WithLabelValues(h.name, name).Inc()
+	}
+	return nil
+}
+
+func (h *hookMetrics) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookMetricsStartKey{}, time.Now()), nil
+}
+
+func (h *hookMetrics) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(hookMetricsStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	commandDurationHistogram.WithLabelValues(h.name, "pipeline").Observe(time.Since(start).Seconds())
+	for i := 0; i < len(cmds); i++ {
+		if isCommandError(cmds[i].Err()) {
+			commandErrorsCounter.WithLabelValues(h.name, strings.ToLower(cmds[i].Name())).Inc()
+		}
+	}
+	return nil
+}
+
+// isCommandError reports whether err is a failure of command. The redis.Nil is not.
+func isCommandError(err error) bool {
+	return err != nil && err != redis.Nil
+}
@@ -0,0 +1,102 @@
+package rediswrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+)
+
+// slowCommandMaxLen is the max length of command that printed in slow log.
+const slowCommandMaxLen = 256
+
+type hookSlowLogStartKey struct{}
+
+// hookSlowLog implements redis.Hook to log the commands that exceed the threshold.
+type hookSlowLog struct {
+	// output is used if no glog.Logger in ctx of command.
+	output    *glog.Logger
+	threshold time.Duration
+}
+
+func (h *hookSlowLog) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookSlowLogStartKey{}, time.Now()), nil
+}
+
+func (h *hookSlowLog) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	start, ok := ctx.Value(hookSlowLogStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	if elapsed := time.Since(start); elapsed > h.threshold {
+		h.logger(ctx).Warn().Msg("redis slow command").
+			String("cmd", formatSlowCommand(cmd)).
+			Millisecond("elapsed", elapsed).
+			Fire()
+	}
+	return nil
+}
+
+func (h *hookSlowLog) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookSlowLogStartKey{}, time.Now()), nil
+}
+
+func (h *hookSlowLog) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(hookSlowLogStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	if elapsed := time.Since(start); elapsed > h.threshold {
+		names := make([]string, len(cmds))
+		for i := range cmds {
+			names[i] = cmds[i].Name()
+		}
+		h.logger(ctx).Warn().Msg("redis slow pipeline").
+			Int("num", len(cmds)).
+			String("cmds", truncateSlowCommand(strings.Join(names, " "))).
+			Millisecond("elapsed", elapsed).
+			Fire()
+	}
+	return nil
+}
+
+func (h *hookSlowLog) logger(ctx context.Context) *glog.Logger {
+	if l := glog.FromContext(ctx); l != nil {
+		return l
+	}
+	return h.output
+}
+
+// slowCommandNoKey is the commands that the first argument is not a key and may be a secret.
+var slowCommandNoKey = map[string]bool{
+	"auth":    true,
+	"hello":   true,
+	"acl":     true,
+	"config":  true,
+	"migrate": true,
+	"eval":    true,
+	"evalsha": true,
+	"script":  true,
+	"publish": true,
+}
+
+// formatSlowCommand returns the command name and the key, the values are omitted because
+// they may contain secrets.
+func formatSlowCommand(cmd redis.Cmder) string {
+	name := cmd.Name()
+	args := cmd.Args()
+	if len(args) < 2 || slowCommandNoKey[name] {
+		return name
+	}
+	return truncateSlowCommand(name + " " + fmt.Sprint(args[1]))
+}
+
+func truncateSlowCommand(s string) string {
+	if len(s) > slowCommandMaxLen {
+		return s[:slowCommandMaxLen] + "..."
+	}
+	return s
+}
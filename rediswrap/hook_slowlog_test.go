@@ -0,0 +1,22 @@
+package rediswrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSlowCommand(t *testing.T) {
+	ctx := context.Background()
+
+	require.Equal(t, "set user:1", formatSlowCommand(redis.NewStatusCmd(ctx, "set", "user:1", "secret-value")))
+	require.Equal(t, "auth", formatSlowCommand(redis.NewStatusCmd(ctx, "auth", "user", "password")))
+	require.Equal(t, "evalsha", formatSlowCommand(redis.NewCmd(ctx, "evalsha", "sha", 1, "key", "secret-value")))
+	require.Equal(t, "ping", formatSlowCommand(redis.NewStatusCmd(ctx, "ping")))
+
+	long := strings.Repeat("k", slowCommandMaxLen*2)
+	require.Equal(t, ("get " + long)[:slowCommandMaxLen]+"...", formatSlowCommand(redis.NewStringCmd(ctx, "get", long)))
+}
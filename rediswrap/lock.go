@@ -0,0 +1,336 @@
+package rediswrap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrLockNotHeld is returned when unlock or extend a lock that not held by the mutex.
+	// It also means the lock was expired and may be acquired by others.
+	ErrLockNotHeld = errors.New("rediswrap: lock not held")
+
+	// ErrLockAlreadyHeld is returned when lock a mutex that is already held by itself.
+	ErrLockAlreadyHeld = errors.New("rediswrap: lock already held")
+)
+
+var (
+	// lockAcquireScript sets the lock key if not exists and returns a new fencing token.
+	// KEYS[1]: lock key; KEYS[2]: fencing key; ARGV[1]: owner value; ARGV[2]: ttl in milliseconds.
+	lockAcquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+	// lockReleaseScript deletes the lock key only if it still held by the owner.
+	// KEYS[1]: lock key; ARGV[1]: owner value.
+	lockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	// lockExtendScript resets the ttl of lock key only if it still held by the owner.
+	// KEYS[1]: lock key; ARGV[1]: owner value; ARGV[2]: ttl in milliseconds.
+	lockExtendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+// MutexOption used to change the behavior of Mutex.
+type MutexOption func(o *mutexOptions)
+
+type mutexOptions struct {
+	ttl           time.Duration
+	retryInterval time.Duration
+	autoExtend    bool
+}
+
+// WithLockTTL sets the lease time of the lock. Defaults 30s.
+func WithLockTTL(ttl time.Duration) MutexOption {
+	return func(o *mutexOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockRetryInterval sets the interval that Lock tries to acquire the lock again. Defaults 100ms.
+func WithLockRetryInterval(d time.Duration) MutexOption {
+	return func(o *mutexOptions) {
+		o.retryInterval = d
+	}
+}
+
+// WithLockAutoExtend controls whether to extend the lease every ttl/3 automatically while
+// the lock is held. Defaults true.
+func WithLockAutoExtend(ok bool) MutexOption {
+	return func(o *mutexOptions) {
+		o.autoExtend = ok
+	}
+}
+
+// Mutex implements a distributed lock based on redis.
+//
+// It works in standalone, sentinel and cluster mode. In cluster mode, the lock key and its
+// fencing key are hashed to the same slot by hash tag, so do not use "{}" in the key.
+//
+// Every successful acquisition returns a fencing token that is monotonically increasing for
+// the same key. Pass it to the protected resource to reject writes from a stale holder.
+//
+// A Mutex should not be used concurrently by multiple goroutines.
+type Mutex struct {
+	client   Client
+	lockKey  string
+	fenceKey string
+	opts     mutexOptions
+
+	mu     sync.Mutex
+	value  string
+	token  int64
+	expire time.Time // the local deadline of the lease, it's earlier than the one in redis.
+	lost   chan struct{}
+	cancel context.CancelFunc
+	exitC  chan struct{}
+}
+
+// NewMutex creates a Mutex for the key.
+func NewMutex(client Client, key string, opts ...MutexOption) *Mutex {
+	m := &Mutex{
+		client:   client,
+		lockKey:  "lock:{" + key + "}",
+		fenceKey: "lock:{" + key + "}:fence",
+		opts: mutexOptions{
+			ttl:           time.Second * 30,
+			retryInterval: time.Millisecond * 100,
+			autoExtend:    true,
+		},
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	return m
+}
+
+// TryLock tries to acquire the lock once and returns immediately.
+func (m *Mutex) TryLock(ctx context.Context) (ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.value != "" {
+		select {
+		case <-m.lost:
+			// The lock was lost, resets the state.
+			m.stopExtend()
+		default:
+			if m.opts.autoExtend || time.Now().Before(m.expire) {
+				return false, ErrLockAlreadyHeld
+			}
+			// The lease expired without extended, resets the state.
+			m.stopExtend()
+		}
+	}
+
+	value, err := newLockValue()
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	token, err := lockAcquireScript.Run(ctx, m.client,
+		[]string{m.lockKey, m.fenceKey}, value, m.opts.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	if token == 0 {
+		return false, nil
+	}
+
+	m.value = value
+	m.token = token
+	m.expire = start.Add(m.opts.ttl)
+	m.lost = make(chan struct{})
+
+	if m.opts.autoExtend {
+		var ctxExtend context.Context
+		ctxExtend, m.cancel = context.WithCancel(context.Background())
+		m.exitC = make(chan struct{})
+		go m.extendLoop(ctxExtend, glog.FromContext(ctx), value, start, m.lost, m.exitC)
+	}
+	return true, nil
+}
+
+// Lock acquires the lock, blocking until it obtained or ctx done.
+func (m *Mutex) Lock(ctx context.Context) error {
+	ticker := time.NewTicker(m.opts.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := m.TryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Unlock releases the lock. Returns ErrLockNotHeld if the lock was expired.
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.value == "" {
+		return ErrLockNotHeld
+	}
+
+	value := m.value
+	m.stopExtend()
+
+	n, err := lockReleaseScript.Run(ctx, m.client, []string{m.lockKey}, value).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend resets the lease of the lock to ttl. It's unnecessary to call it if auto extend enabled.
+func (m *Mutex) Extend(ctx context.Context) error {
+	m.mu.Lock()
+	value := m.value
+	m.mu.Unlock()
+
+	if value == "" {
+		return ErrLockNotHeld
+	}
+
+	start := time.Now()
+	if err := m.extend(ctx, value); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.value == value {
+		m.expire = start.Add(m.opts.ttl)
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Token returns the fencing token of the current holding. Returns 0 if the lock is not held.
+func (m *Mutex) Token() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+// Done returns a channel that's closed when the lock is released or lost (the lease expired
+// before extended). Returns nil if the lock is not held.
+//
+// The lost is only detected by the auto extend, so the channel is closed by Unlock or the next
+// TryLock if auto extend disabled.
+func (m *Mutex) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// stopExtend stops the extend goroutine and resets the holding state. Must be called with m.mu held.
+func (m *Mutex) stopExtend() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.exitC
+		m.cancel = nil
+		m.exitC = nil
+	}
+	if m.lost != nil {
+		select {
+		case <-m.lost:
+		default:
+			close(m.lost)
+		}
+	}
+	m.value = ""
+	m.token = 0
+	m.expire = time.Time{}
+}
+
+func (m *Mutex) extend(ctx context.Context, value string) error {
+	n, err := lockExtendScript.Run(ctx, m.client, []string{m.lockKey}, value, m.opts.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// extendLoop extends the lease every ttl/3 until canceled or the lock lost.
+// The lock is treated as lost if not extended successfully in ttl since the lastOK.
+func (m *Mutex) extendLoop(ctx context.Context, lg *glog.Logger, value string, lastOK time.Time, lost chan struct{}, exitC chan struct{}) {
+	defer close(exitC)
+
+	interval := m.opts.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		start := time.Now()
+		ctxTimeout, cancel := context.WithTimeout(ctx, interval)
+		err := m.extend(ctxTimeout, value)
+		cancel()
+
+		switch {
+		case err == nil:
+			lastOK = start
+		case errors.Is(err, ErrLockNotHeld):
+			lg.Warn().Msg("redis: lock lost before extended").String("key", m.lockKey).Fire()
+			close(lost)
+			return
+		case ctx.Err() != nil:
+			return
+		case time.Since(lastOK) >= m.opts.ttl:
+			lg.Warn().Msg("redis: lock lease expired before extended").String("key", m.lockKey).Error("error", err).Fire()
+			close(lost)
+			return
+		default:
+			// Try again in next tick; the lock will be lost if the error persists beyond ttl.
+			lg.Error().Msg("redis: extend lock error").String("key", m.lockKey).Error("error", err).Fire()
+		}
+	}
+}
+
+func newLockValue() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
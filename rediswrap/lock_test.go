@@ -0,0 +1,151 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutex_TryLock(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	m1 := NewMutex(rdb, "test")
+	m2 := NewMutex(rdb, "test")
+
+	ok, err := m1.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(1), m1.Token())
+
+	_, err = m1.TryLock(ctx)
+	require.Equal(t, ErrLockAlreadyHeld, err)
+
+	ok, err = m2.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.Equal(t, int64(0), m2.Token())
+
+	done := m1.Done()
+	require.Nil(t, m1.Unlock(ctx))
+	require.Equal(t, ErrLockNotHeld, m1.Unlock(ctx))
+	select {
+	case <-done:
+	default:
+		t.Fatal("Done channel not closed after unlock")
+	}
+
+	ok, err = m2.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	// The fencing token increases.
+	require.Equal(t, int64(2), m2.Token())
+	require.Nil(t, m2.Unlock(ctx))
+}
+
+func TestMutex_Lock(t *testing.T) {
+	_, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	m1 := NewMutex(rdb, "test", WithLockRetryInterval(time.Millisecond*10))
+	m2 := NewMutex(rdb, "test", WithLockRetryInterval(time.Millisecond*10))
+
+	require.Nil(t, m1.Lock(ctx))
+
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	err := m2.Lock(ctxTimeout)
+	cancel()
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = m1.Unlock(ctx)
+	}()
+	require.Nil(t, m2.Lock(ctx))
+	require.Equal(t, int64(2), m2.Token())
+	require.Nil(t, m2.Unlock(ctx))
+}
+
+func TestMutex_AutoExtend(t *testing.T) {
+	mr, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	ttl := time.Millisecond * 300
+	m := NewMutex(rdb, "test", WithLockTTL(ttl))
+	ok, err := m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// Wait for extended at least once and make the time forward.
+	time.Sleep(ttl / 2)
+	mr.FastForward(ttl / 2)
+	require.True(t, mr.Exists("lock:{test}"))
+	require.Greater(t, int64(mr.TTL("lock:{test}")), int64(ttl/3))
+
+	// The lock expired and the extend will fail.
+	mr.FastForward(ttl)
+	require.False(t, mr.Exists("lock:{test}"))
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done channel not closed after lock lost")
+	}
+	require.Equal(t, ErrLockNotHeld, m.Extend(ctx))
+
+	// The lock can be acquired again after lost.
+	ok, err = m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, m.Unlock(ctx))
+}
+
+func TestMutex_AutoExtendError(t *testing.T) {
+	mr, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	ttl := time.Millisecond * 300
+	m := NewMutex(rdb, "test", WithLockTTL(ttl))
+	ok, err := m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// The lock is lost if the extend keeps failing for ttl.
+	mr.SetError("ERR mock error")
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done channel not closed after extend failed for ttl")
+	}
+	mr.SetError("")
+	mr.FastForward(ttl)
+
+	ok, err = m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, m.Unlock(ctx))
+}
+
+func TestMutex_NoAutoExtend(t *testing.T) {
+	mr, rdb := newTestClient(t)
+	ctx := newTestContext()
+
+	ttl := time.Millisecond * 100
+	m := NewMutex(rdb, "test", WithLockTTL(ttl), WithLockAutoExtend(false))
+	ok, err := m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	_, err = m.TryLock(ctx)
+	require.Equal(t, ErrLockAlreadyHeld, err)
+
+	// The lease expired, the lock can be acquired again.
+	time.Sleep(ttl)
+	mr.FastForward(ttl)
+	ok, err = m.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(2), m.Token())
+	require.Nil(t, m.Unlock(ctx))
+}
@@ -0,0 +1,86 @@
+package rediswrap
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "redis"
+
+var (
+	cacheRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "How many cache lookups processed, partitioned by cache name and result(hit, miss, negative_hit, error).",
+		},
+		[]string{"name", "result"},
+	)
+
+	cacheLoadDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: "cache",
+			Name:      "load_duration_seconds",
+			Help:      "The latencies in seconds of loading values when cache miss, partitioned by cache name and result(success, not_found, error).",
+		},
+		[]string{"name", "result"},
+	)
+)
+
+var (
+	cacheLocalRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "cache",
+			Name:      "local_requests_total",
+			Help:      "How many local cache lookups processed, partitioned by cache name and result(hit, miss).",
+		},
+		[]string{"name", "result"},
+	)
+
+	cacheLocalInvalidationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "cache",
+			Name:      "local_invalidations_total",
+			Help:      "How many local cache keys invalidated by messages from other replicas, partitioned by cache name.",
+		},
+		[]string{"name"},
+	)
+)
+
+var (
+	commandDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: "command",
+			Name:      "duration_seconds",
+			Help:      "The latencies in seconds of redis commands, partitioned by client name and command.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		},
+		[]string{"name", "cmd"},
+	)
+
+	commandErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "command",
+			Name:      "errors_total",
+			Help:      "How many redis commands failed, partitioned by client name and command.",
+		},
+		[]string{"name", "cmd"},
+	)
+
+	poolStats = newPoolStatsCollector()
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequestsCounter)
+	prometheus.MustRegister(cacheLoadDurationHistogram)
+	prometheus.MustRegister(cacheLocalRequestsCounter)
+	prometheus.MustRegister(cacheLocalInvalidationsCounter)
+	prometheus.MustRegister(commandDurationHistogram)
+	prometheus.MustRegister(commandErrorsCounter)
+	prometheus.MustRegister(poolStats)
+}
@@ -0,0 +1,132 @@
+package rediswrap
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ prometheus.Collector = (*poolStatsCollector)(nil)
+)
+
+// poolStater returns the stats of connection pool. All clients of go-redis implement it.
+type poolStater interface {
+	PoolStats() *redis.PoolStats
+}
+
+// poolStatsCollector implements prometheus.Collector to collects the connection pool stats
+// of all clients created by NewRedisConn. The stats of clients with the same name are summed.
+//
+// The counters of removed clients are kept in removed, so the summed counters never go backwards.
+type poolStatsCollector struct {
+	mu      sync.Mutex
+	clients map[poolStater]string // name by client
+	removed map[string]*redis.PoolStats
+
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newPoolStatsCollector() *poolStatsCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricNamespace, "pool", name), help, []string{"name"}, nil)
+	}
+	return &poolStatsCollector{
+		clients:    make(map[poolStater]string),
+		removed:    make(map[string]*redis.PoolStats),
+		hits:       desc("hits_total", "Number of times free connection was found in the pool."),
+		misses:     desc("misses_total", "Number of times free connection was NOT found in the pool."),
+		timeouts:   desc("timeouts_total", "Number of times a wait timeout occurred."),
+		totalConns: desc("conns", "Number of total connections in the pool."),
+		idleConns:  desc("idle_conns", "Number of idle connections in the pool."),
+		staleConns: desc("stale_conns_total", "Number of stale connections removed from the pool."),
+	}
+}
+
+// add tracks the client until it's removed. The client that not implements poolStater is ignored.
+func (c *poolStatsCollector) add(name string, client Client) {
+	s, ok := client.(poolStater)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.clients[s] = name
+	c.mu.Unlock()
+}
+
+// remove stops tracking the client and accumulates its counters.
+func (c *poolStatsCollector) remove(client Client) {
+	stater, ok := client.(poolStater)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.clients[stater]
+	if !ok {
+		return
+	}
+	delete(c.clients, stater)
+
+	s := stater.PoolStats()
+	sum, ok := c.removed[name]
+	if !ok {
+		sum = &redis.PoolStats{}
+		c.removed[name] = sum
+	}
+	sum.Hits += s.Hits
+	sum.Misses += s.Misses
+	sum.Timeouts += s.Timeouts
+	sum.StaleConns += s.StaleConns
+}
+
+// Describe implements prometheus.Collector.
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.totalConns
+	ch <- c.idleConns
+	ch <- c.staleConns
+}
+
+// Collect implements prometheus.Collector.
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	stats := make(map[string]*redis.PoolStats, len(c.clients)+len(c.removed))
+	for name, s := range c.removed {
+		sum := *s
+		stats[name] = &sum
+	}
+	for client, name := range c.clients {
+		s := client.PoolStats()
+		if sum, ok := stats[name]; ok {
+			sum.Hits += s.Hits
+			sum.Misses += s.Misses
+			sum.Timeouts += s.Timeouts
+			sum.TotalConns += s.TotalConns
+			sum.IdleConns += s.IdleConns
+			sum.StaleConns += s.StaleConns
+		} else {
+			stats[name] = s
+		}
+	}
+	c.mu.Unlock()
+
+	for name, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits), name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses), name)
+		ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts), name)
+		ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns), name)
+		ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns), name)
+		ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(s.StaleConns), name)
+	}
+}
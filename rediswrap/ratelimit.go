@@ -0,0 +1,202 @@
+package rediswrap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// slidingWindowScript records a request in a sorted set if the number of requests in the
+	// last window is less than the limit.
+	// KEYS[1]: window key; ARGV[1]: now in milliseconds; ARGV[2]: window in milliseconds;
+	// ARGV[3]: limit; ARGV[4]: unique member of this request.
+	// Returns {allowed, remaining, retry_after_ms, reset_after_ms}.
+	slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	redis.call("PEXPIRE", KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = 0
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if #oldest > 0 then
+	reset = tonumber(oldest[2]) + window - now
+end
+if allowed == 1 then
+	return {1, limit - count, 0, reset}
+end
+return {0, 0, reset, reset}
+`)
+
+	// tokenBucketScript takes a token from the bucket after refilling it by the elapsed time.
+	// KEYS[1]: bucket key; ARGV[1]: now in milliseconds; ARGV[2]: refill rate in tokens per
+	// millisecond; ARGV[3]: burst.
+	// Returns {allowed, remaining, retry_after_ms, reset_after_ms}.
+	tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+local reset = math.ceil((burst - tokens) / rate)
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.max(reset, 1))
+return {allowed, math.floor(tokens), retry, reset}
+`)
+)
+
+// RateLimitResult is the result of a rate limit check.
+type RateLimitResult struct {
+	// Allowed reports whether the request is allowed.
+	Allowed bool
+	// Limit is the max number of requests in a window or the burst of a bucket.
+	Limit int64
+	// Remaining is the number of requests that still allowed right now.
+	Remaining int64
+	// RetryAfter is the time to wait before the next request will be allowed.
+	// It is zero if the request is allowed.
+	RetryAfter time.Duration
+	// ResetAfter is the time until the limiter returns to its initial state.
+	ResetAfter time.Duration
+}
+
+// RateLimiter checks whether a request identified by key is allowed.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (*RateLimitResult, error)
+}
+
+// SlidingWindowLimiter allows at most limit requests in any window for the same key.
+//
+// Every request is recorded in a sorted set, so the memory cost is proportional to the limit.
+// The time is taken from the local clock, so keep the clocks of all instances in sync.
+type SlidingWindowLimiter struct {
+	client Client
+	prefix string
+	limit  int64
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindowLimiter creates a SlidingWindowLimiter. The name is used to distinguish
+// the keys of different limiters. It panics if limit or window is not positive.
+func NewSlidingWindowLimiter(client Client, name string, limit int64, window time.Duration) *SlidingWindowLimiter {
+	if limit <= 0 || window < time.Millisecond {
+		panic(fmt.Sprintf("rediswrap: invalid sliding window limit %d in %s", limit, window))
+	}
+	return &SlidingWindowLimiter{
+		client: client,
+		prefix: "ratelimit:" + name + ":",
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Allow implements RateLimiter.
+func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	member, err := newLockValue()
+	if err != nil {
+		return nil, err
+	}
+	now := l.now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{now, l.window.Milliseconds(), l.limit, strconv.FormatInt(now, 10) + "-" + member}
+	values, err := slidingWindowScript.Run(ctx, l.client, []string{l.prefix + key}, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitResult(l.limit, values)
+}
+
+// TokenBucketLimiter allows bursts of up to burst requests and refills the bucket at a
+// constant rate for the same key.
+//
+// The time is taken from the local clock, so keep the clocks of all instances in sync.
+type TokenBucketLimiter struct {
+	client Client
+	prefix string
+	rate   float64
+	burst  int64
+	now    func() time.Time
+}
+
+// NewTokenBucketLimiter creates a TokenBucketLimiter that refills rate tokens per second.
+// The name is used to distinguish the keys of different limiters. It panics if rate or burst
+// is not positive.
+func NewTokenBucketLimiter(client Client, name string, rate float64, burst int64) *TokenBucketLimiter {
+	if !(rate > 0) || burst <= 0 {
+		panic(fmt.Sprintf("rediswrap: invalid token bucket rate %g and burst %d", rate, burst))
+	}
+	return &TokenBucketLimiter{
+		client: client,
+		prefix: "ratelimit:" + name + ":",
+		rate:   rate,
+		burst:  burst,
+		now:    time.Now,
+	}
+}
+
+// Allow implements RateLimiter.
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	now := l.now().UnixNano() / int64(time.Millisecond)
+	args := []interface{}{now, strconv.FormatFloat(l.rate/1000, 'g', -1, 64), l.burst}
+	values, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key}, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitResult(l.burst, values)
+}
+
+func newRateLimitResult(limit int64, reply interface{}) (*RateLimitResult, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("rediswrap: unexpected rate limit reply %v", reply)
+	}
+	n := make([]int64, len(values))
+	for i, v := range values {
+		x, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("rediswrap: unexpected rate limit reply %v", values)
+		}
+		n[i] = x
+	}
+	r := &RateLimitResult{
+		Allowed:    n[0] == 1,
+		Limit:      limit,
+		Remaining:  n[1],
+		RetryAfter: time.Duration(n[2]) * time.Millisecond,
+		ResetAfter: time.Duration(n[3]) * time.Millisecond,
+	}
+	return r, nil
+}
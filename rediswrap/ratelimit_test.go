@@ -0,0 +1,90 @@
+package rediswrap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := newTestContext()
+
+	now := time.Unix(1600000000, 0)
+	l := NewSlidingWindowLimiter(client, "api", 3, time.Second*10)
+	l.now = func() time.Time { return now }
+
+	for i := int64(0); i < 3; i++ {
+		r, err := l.Allow(ctx, "user-1")
+		require.Nil(t, err)
+		require.True(t, r.Allowed)
+		require.Equal(t, int64(3), r.Limit)
+		require.Equal(t, 2-i, r.Remaining)
+		now = now.Add(time.Second)
+	}
+
+	r, err := l.Allow(ctx, "user-1")
+	require.Nil(t, err)
+	require.False(t, r.Allowed)
+	require.Equal(t, int64(0), r.Remaining)
+	require.Equal(t, time.Second*7, r.RetryAfter)
+
+	// Other keys are not affected.
+	r, err = l.Allow(ctx, "user-2")
+	require.Nil(t, err)
+	require.True(t, r.Allowed)
+
+	// The first request slides out of the window.
+	now = now.Add(time.Second * 7)
+	r, err = l.Allow(ctx, "user-1")
+	require.Nil(t, err)
+	require.True(t, r.Allowed)
+	require.Equal(t, int64(0), r.Remaining)
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := newTestContext()
+
+	now := time.Unix(1600000000, 0)
+	l := NewTokenBucketLimiter(client, "api", 2, 4)
+	l.now = func() time.Time { return now }
+
+	for i := int64(0); i < 4; i++ {
+		r, err := l.Allow(ctx, "key-1")
+		require.Nil(t, err)
+		require.True(t, r.Allowed)
+		require.Equal(t, int64(4), r.Limit)
+		require.Equal(t, 3-i, r.Remaining)
+	}
+
+	r, err := l.Allow(ctx, "key-1")
+	require.Nil(t, err)
+	require.False(t, r.Allowed)
+	require.Equal(t, time.Millisecond*500, r.RetryAfter)
+	require.Equal(t, time.Second*2, r.ResetAfter)
+
+	// Refills one token per 500ms.
+	now = now.Add(time.Millisecond * 500)
+	r, err = l.Allow(ctx, "key-1")
+	require.Nil(t, err)
+	require.True(t, r.Allowed)
+	require.Equal(t, int64(0), r.Remaining)
+
+	// Never exceeds the burst.
+	now = now.Add(time.Minute)
+	r, err = l.Allow(ctx, "key-1")
+	require.Nil(t, err)
+	require.True(t, r.Allowed)
+	require.Equal(t, int64(3), r.Remaining)
+}
+
+func TestRateLimiterInvalidArgs(t *testing.T) {
+	_, client := newTestClient(t)
+
+	require.Panics(t, func() { NewTokenBucketLimiter(client, "api", 0, 4) })
+	require.Panics(t, func() { NewTokenBucketLimiter(client, "api", 1, 0) })
+	require.Panics(t, func() { NewSlidingWindowLimiter(client, "api", 0, time.Second) })
+	require.Panics(t, func() { NewSlidingWindowLimiter(client, "api", 1, 0) })
+}
@@ -0,0 +1,549 @@
+package rediswrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/idgenerator"
+)
+
+// Fields added to the message of dead-letter stream.
+const (
+	DeadLetterStreamField     = "_dead_stream"
+	DeadLetterIdField         = "_dead_id"
+	DeadLetterGroupField      = "_dead_group"
+	DeadLetterDeliveriesField = "_dead_deliveries"
+)
+
+// StreamMessage is a message read from redis stream.
+type StreamMessage struct {
+	Stream string
+	ID     string
+	Values map[string]interface{}
+	// Deliveries is the number of times that the message was delivered to the consumer group,
+	// it's 1 for a new message.
+	Deliveries int64
+}
+
+// StreamHandler callback the consumed messages, these messages are come from the same stream in every calls.
+//
+// The function parameters:
+//   - ctx: The value includes traceId, glog.Logger and opentracing.Span(if tracer is enabled).
+//     And you can use the `<- ctx.Done()` to monitor whether StreamConsumer is closed.
+//   - messages: The `messages` always at least one message and at most the batch max.
+//
+// If nil error was returns, the messages will be acknowledged. Otherwise, the messages will be retried
+// in place, then be left in the pending list and delivered again by claiming after the claim min idle.
+// Messages delivered more than the max deliveries are moved to the dead-letter stream.
+type StreamHandler func(ctx context.Context, messages []*StreamMessage) (err error)
+
+type streamHandlerInterceptor func(ctx context.Context, messages []*StreamMessage, handler StreamHandler) (err error)
+
+func chainStreamInterceptors(interceptors []streamHandlerInterceptor) streamHandlerInterceptor {
+	return func(ctx context.Context, messages []*StreamMessage, handler StreamHandler) (err error) {
+		return interceptors[0](ctx, messages, getStreamChainHandler(interceptors, 0, handler))
+	}
+}
+
+func getStreamChainHandler(interceptors []streamHandlerInterceptor, curr int, finalHandler StreamHandler) StreamHandler {
+	if curr == len(interceptors)-1 {
+		return finalHandler
+	}
+	return func(ctx context.Context, messages []*StreamMessage) (err error) {
+		return interceptors[curr+1](ctx, messages, getStreamChainHandler(interceptors, curr+1, finalHandler))
+	}
+}
+
+// StreamConsumerOption used to change the behavior of StreamConsumer.
+type StreamConsumerOption func(o *streamConsumerOptions)
+
+type streamConsumerOptions struct {
+	batchMax      int64
+	block         time.Duration
+	retries       int
+	retryInterval time.Duration
+	maxDeliveries int64
+	claimMinIdle  time.Duration
+	claimInterval time.Duration
+	deadLetter    string
+	startId       string
+}
+
+// WithStreamBatchMax sets the maximum messages of consumed at once. Defaults 64.
+func WithStreamBatchMax(n int64) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.batchMax = n
+	}
+}
+
+// WithStreamBlock sets the max time to block for waiting new messages. Defaults 2s.
+func WithStreamBlock(d time.Duration) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.block = d
+	}
+}
+
+// WithStreamRetry sets the times and interval to retry in place when handler returns error.
+// Defaults 2 times and 1s.
+func WithStreamRetry(retries int, interval time.Duration) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.retries = retries
+		o.retryInterval = interval
+	}
+}
+
+// WithStreamMaxDeliveries sets the max times that a message may be delivered. The message
+// is moved to dead-letter stream when exceeds. Defaults 5.
+func WithStreamMaxDeliveries(n int64) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.maxDeliveries = n
+	}
+}
+
+// WithStreamClaim sets the min idle time of pending messages to be claimed from other consumers
+// or failed in this consumer, and the interval to check pending messages. Defaults 1m and 30s.
+func WithStreamClaim(minIdle time.Duration, interval time.Duration) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.claimMinIdle = minIdle
+		o.claimInterval = interval
+	}
+}
+
+// WithStreamDeadLetter sets the dead-letter stream. Defaults "<stream>:dead".
+func WithStreamDeadLetter(stream string) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.deadLetter = stream
+	}
+}
+
+// WithStreamStartID sets the id that the consumer group starts from if it's created by
+// the StreamConsumer. Defaults "$" means only new messages.
+func WithStreamStartID(id string) StreamConsumerOption {
+	return func(o *streamConsumerOptions) {
+		o.startId = id
+	}
+}
+
+// cmdDoer sends raw commands. Both *redis.Client and *redis.ClusterClient implement it.
+type cmdDoer interface {
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+}
+
+// StreamConsumer consumes messages of a redis stream as a member of consumer group.
+type StreamConsumer struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lp       *glog.Logger
+	client   Client
+	doer     cmdDoer
+	stream   string
+	group    string
+	consumer string
+	handler  StreamHandler
+	tracer   opentracing.Tracer
+	opts     streamConsumerOptions
+
+	// Initialize inside.
+	idGen       *idgenerator.IDGenerator
+	interceptor streamHandlerInterceptor
+	wg          sync.WaitGroup
+}
+
+// NewStreamConsumer creates a StreamConsumer, the consumer group is created if not exists.
+//
+// The consumer name must be unique and stable in the group, e.g. the hostname of pod.
+// The client must implement Do to claim pending messages by XAUTOCLAIM.
+func NewStreamConsumer(ctx context.Context, client Client, stream, group, consumer string,
+	handler StreamHandler, opts ...StreamConsumerOption) (*StreamConsumer, error) {
+	if handler == nil {
+		panic("StreamConsumer: handler can not be nil")
+	}
+	doer, ok := client.(cmdDoer)
+	if !ok {
+		return nil, fmt.Errorf("StreamConsumer: client %T does not implement Do", client)
+	}
+
+	lp := glog.FromContext(ctx).Clone()
+	lp.WithFields().AddString("stream", stream)
+	lp.WithFields().AddString("group", group)
+	lp.WithFields().AddString("consumer", consumer)
+
+	c := &StreamConsumer{
+		lp:       lp,
+		client:   client,
+		doer:     doer,
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		handler:  handler,
+		tracer:   gtrace.TracerFromContext(ctx),
+		opts: streamConsumerOptions{
+			batchMax:      64,
+			block:         time.Second * 2,
+			retries:       2,
+			retryInterval: time.Second,
+			maxDeliveries: 5,
+			claimMinIdle:  time.Minute,
+			claimInterval: time.Second * 30,
+			deadLetter:    stream + ":dead",
+			startId:       "$",
+		},
+		idGen: idgenerator.New(""),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+
+	err := client.XGroupCreateMkStream(ctx, stream, group, c.opts.startId).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		lp.Error().Error("StreamConsumer: create consumer group error", err).Fire()
+		return nil, err
+	}
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.interceptor = chainStreamInterceptors([]streamHandlerInterceptor{
+		c.prepareHandler,
+		c.retryHandler,
+		c.spanHandler,
+	})
+	return c, nil
+}
+
+// Consume starts consuming in a loop until the consumer closed. Errors of redis are logged and retried.
+//
+// The messages left in pending list of this consumer are processed first.
+//
+// This function does not allow concurrent calls.
+func (c *StreamConsumer) Consume() (err error) {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	ctx := c.ctx
+	lg := c.lp
+	lg.Debug().Msg("StreamConsumer: loop up and running").Fire()
+
+	// Reads the history of this consumer from "0" until empty, then the new messages by ">".
+	lastId := "0"
+	var lastClaim time.Time
+
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if time.Since(lastClaim) >= c.opts.claimInterval {
+			if err = c.claim(ctx); err != nil && ctx.Err() == nil {
+				lg.Error().Error("StreamConsumer: claim pending messages error", err).Fire()
+			}
+			lastClaim = time.Now()
+		}
+
+		var messages []*StreamMessage
+		messages, err = c.read(ctx, lastId)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			lg.Error().Error("StreamConsumer: read messages error", err).Fire()
+			select {
+			case <-time.After(c.opts.retryInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if len(messages) == 0 {
+			lastId = ">"
+			continue
+		}
+		if lastId != ">" {
+			// Continue reading history after the last one.
+			lastId = messages[len(messages)-1].ID
+			if err = c.fillDeliveries(ctx, messages); err != nil {
+				continue
+			}
+			messages = c.deadLetter(ctx, messages)
+			if len(messages) == 0 {
+				continue
+			}
+		}
+		c.process(ctx, messages)
+	}
+
+	lg.Debug().Msg("StreamConsumer: consumer was closed, stops").Fire()
+	return nil
+}
+
+// Close stops the consumer and waits for the Consume exits.
+func (c *StreamConsumer) Close() (err error) {
+	if c == nil {
+		return
+	}
+	c.lp.Debug().Msg("StreamConsumer: wait for the consumer to close").Fire()
+	c.cancel()
+	c.wg.Wait()
+	c.lp.Debug().Msg("StreamConsumer: consumer successful closed").Fire()
+	_ = c.lp.Close()
+	return
+}
+
+func (c *StreamConsumer) read(ctx context.Context, id string) ([]*StreamMessage, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  []string{c.stream, id},
+		Count:    c.opts.batchMax,
+	}
+	if id == ">" {
+		args.Block = c.opts.block
+	}
+	streams, err := c.client.XReadGroup(ctx, args).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*StreamMessage
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			messages = append(messages, &StreamMessage{Stream: c.stream, ID: m.ID, Values: m.Values, Deliveries: 1})
+		}
+	}
+	return messages, nil
+}
+
+// claim takes the pending messages that idle more than claimMinIdle by XAUTOCLAIM and process them.
+func (c *StreamConsumer) claim(ctx context.Context) error {
+	start := "0-0"
+	for {
+		// Sends the raw command, the reply of redis 7.0 has an additional element of deleted ids.
+		reply, err := c.doer.Do(ctx, "XAUTOCLAIM", c.stream, c.group, c.consumer,
+			c.opts.claimMinIdle.Milliseconds(), start, "COUNT", c.opts.batchMax).Result()
+		if err != nil {
+			return err
+		}
+		next, messages, deleted, err := c.parseAutoClaim(reply)
+		if err != nil {
+			return err
+		}
+		if len(deleted) > 0 {
+			// The deleted messages stay in pending list in redis 6.2.
+			if err = c.client.XAck(ctx, c.stream, c.group, deleted...).Err(); err != nil {
+				return err
+			}
+		}
+		if len(messages) > 0 {
+			c.lp.Debug().Msg("StreamConsumer: claimed pending messages").Int("num", len(messages)).Fire()
+			if err = c.fillDeliveries(ctx, messages); err != nil {
+				return err
+			}
+			messages = c.deadLetter(ctx, messages)
+			if len(messages) > 0 {
+				c.process(ctx, messages)
+			}
+		}
+		if next == "0-0" || ctx.Err() != nil {
+			return nil
+		}
+		start = next
+	}
+}
+
+func (c *StreamConsumer) parseAutoClaim(reply interface{}) (next string, messages []*StreamMessage, deleted []string, err error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 2 {
+		err = fmt.Errorf("rediswrap: unexpected XAUTOCLAIM reply %v", reply)
+		return
+	}
+	if next, ok = values[0].(string); !ok {
+		err = fmt.Errorf("rediswrap: unexpected XAUTOCLAIM reply %v", reply)
+		return
+	}
+	entries, _ := values[1].([]interface{})
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			err = fmt.Errorf("rediswrap: unexpected XAUTOCLAIM entry %v", e)
+			return
+		}
+		id, _ := entry[0].(string)
+		fields, ok := entry[1].([]interface{})
+		if !ok {
+			// The message was deleted.
+			deleted = append(deleted, id)
+			continue
+		}
+		m := &StreamMessage{Stream: c.stream, ID: id, Values: make(map[string]interface{}, len(fields)/2)}
+		for i := 0; i+1 < len(fields); i += 2 {
+			k, _ := fields[i].(string)
+			m.Values[k] = fields[i+1]
+		}
+		messages = append(messages, m)
+	}
+	return
+}
+
+// fillDeliveries sets the deliveries of messages from pending list.
+func (c *StreamConsumer) fillDeliveries(ctx context.Context, messages []*StreamMessage) error {
+	cmds := make([]*redis.XPendingExtCmd, len(messages))
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, m := range messages {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: c.stream,
+				Group:  c.group,
+				Start:  m.ID,
+				End:    m.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		c.lp.Error().Error("StreamConsumer: get deliveries of pending messages error", err).Fire()
+		return err
+	}
+	for i, m := range messages {
+		if pending := cmds[i].Val(); len(pending) == 1 {
+			m.Deliveries = pending[0].RetryCount
+		}
+	}
+	return nil
+}
+
+// deadLetter moves the messages that exceed max deliveries to dead-letter stream and returns the others.
+func (c *StreamConsumer) deadLetter(ctx context.Context, messages []*StreamMessage) []*StreamMessage {
+	alive := messages[:0]
+	for _, m := range messages {
+		if m.Deliveries <= c.opts.maxDeliveries {
+			alive = append(alive, m)
+			continue
+		}
+
+		values := make(map[string]interface{}, len(m.Values)+4)
+		for k, v := range m.Values {
+			values[k] = v
+		}
+		values[DeadLetterStreamField] = c.stream
+		values[DeadLetterIdField] = m.ID
+		values[DeadLetterGroupField] = c.group
+		values[DeadLetterDeliveriesField] = m.Deliveries
+
+		// The dead-letter stream may be in another slot in cluster mode, so does not use transaction.
+		// The message may be duplicated in dead-letter stream if XACK failed.
+		_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: c.opts.deadLetter, ID: "*", Values: values})
+			pipe.XAck(ctx, c.stream, c.group, m.ID)
+			return nil
+		})
+		if err != nil {
+			c.lp.Error().Msg("StreamConsumer: move message to dead-letter stream failed").
+				String("id", m.ID).Error("error", err).Fire()
+			continue
+		}
+		c.lp.Warn().Msg("StreamConsumer: moved message to dead-letter stream").
+			String("id", m.ID).Int64("deliveries", m.Deliveries).String("dead_letter", c.opts.deadLetter).Fire()
+	}
+	return alive
+}
+
+// process the messages and acknowledges them if handler success.
+func (c *StreamConsumer) process(ctx context.Context, messages []*StreamMessage) {
+	if err := c.interceptor(ctx, messages, c.handler); err != nil {
+		// Left in pending list and retry by claim.
+		return
+	}
+	ids := make([]string, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	if err := c.client.XAck(ctx, c.stream, c.group, ids...).Err(); err != nil {
+		c.lp.Error().Error("StreamConsumer: ack messages error", err).Fire()
+	}
+}
+
+// prepareHandler creates new log object and get trace id and saved to `context.Context`.
+func (c *StreamConsumer) prepareHandler(ctx context.Context, messages []*StreamMessage, handler StreamHandler) (err error) {
+	nl := c.lp.Clone()
+
+	// Only get the trace id in first message.
+	tid, _ := messages[0].Values[gtrace.IdKey].(string)
+	if tid == "" {
+		tid, _ = c.idGen.Take()
+	}
+	ctx = gtrace.ContextWithId(ctx, tid)
+	nl.WithFields().AddString("tid", tid)
+	ctx = glog.WithContext(ctx, nl)
+
+	err = handler(ctx, messages)
+
+	_ = nl.Close()
+	return
+}
+
+// retryHandler retries in place until the messages handle successes or exceeds the retries.
+func (c *StreamConsumer) retryHandler(ctx context.Context, messages []*StreamMessage, handler StreamHandler) (err error) {
+	lg := glog.FromContext(ctx)
+
+	lg.Debug().Msg("StreamConsumer: received messages").
+		String("first_id", messages[0].ID).
+		String("last_id", messages[len(messages)-1].ID).
+		Int("num", len(messages)).
+		Fire()
+
+	for retries := 0; ; retries++ {
+		err = handler(ctx, messages)
+		if err == nil || err == context.Canceled {
+			return
+		}
+		lg.Error().Error("StreamConsumer: handle messages error", err).Int("retrying", retries).Fire()
+		if retries >= c.opts.retries {
+			return
+		}
+		select {
+		case <-time.After(c.opts.retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// spanHandler opens a trace span. References from message fields and `context.Context`.
+func (c *StreamConsumer) spanHandler(ctx context.Context, messages []*StreamMessage, handler StreamHandler) (err error) {
+	// Only trace the first message.
+	producerSpan, err := c.tracer.Extract(opentracing.TextMap, streamCarrier(messages[0].Values))
+	if err != nil && err != opentracing.ErrSpanContextNotFound {
+		glog.FromContext(ctx).Error().Error("StreamConsumer: extract SpanContext from message error", err).Fire()
+	}
+	var parentSpan opentracing.SpanContext
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		parentSpan = span.Context()
+	}
+
+	span := c.tracer.StartSpan(
+		"StreamConsumeMessage",
+		opentracing.ChildOf(producerSpan),
+		opentracing.ChildOf(parentSpan),
+		ext.SpanKindConsumer,
+		streamComponentTag,
+		opentracing.Tags{"stream": c.stream, "group": c.group, "id": messages[0].ID, "message.num": len(messages)},
+	)
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	if err = handler(ctx, messages); err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+	}
+	span.Finish()
+	return
+}
@@ -0,0 +1,84 @@
+package rediswrap
+
+import (
+	"context"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+// StreamProducerOption used to change the behavior of StreamProducer.
+type StreamProducerOption func(o *streamProducerOptions)
+
+type streamProducerOptions struct {
+	maxLen int64
+}
+
+// WithStreamMaxLen trims the stream to about n entries when sending messages.
+// Defaults 0 means never trim.
+func WithStreamMaxLen(n int64) StreamProducerOption {
+	return func(o *streamProducerOptions) {
+		o.maxLen = n
+	}
+}
+
+// StreamProducer sends messages to redis streams.
+//
+// The trace id and span context in ctx are injected into the message fields, so that
+// the StreamConsumer can continue the trace.
+type StreamProducer struct {
+	client Client
+	tracer opentracing.Tracer
+	opts   streamProducerOptions
+}
+
+// NewStreamProducer creates a StreamProducer.
+func NewStreamProducer(ctx context.Context, client Client, opts ...StreamProducerOption) *StreamProducer {
+	p := &StreamProducer{
+		client: client,
+		tracer: gtrace.TracerFromContext(ctx),
+	}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	return p
+}
+
+// Send appends a message with values to stream and returns the id of message.
+func (p *StreamProducer) Send(ctx context.Context, stream string, values map[string]interface{}) (id string, err error) {
+	lg := glog.FromContext(ctx)
+
+	// Copy the values to avoid modifying by trace fields.
+	fields := make(map[string]interface{}, len(values)+2)
+	for k, v := range values {
+		fields[k] = v
+	}
+	span := streamProducerSpan(ctx, p.tracer, stream, fields)
+
+	args := &redis.XAddArgs{
+		Stream: stream,
+		ID:     "*",
+		Values: fields,
+	}
+	if p.opts.maxLen > 0 {
+		args.MaxLen = p.opts.maxLen
+		args.Approx = true
+	}
+
+	id, err = p.client.XAdd(ctx, args).Result()
+	if err != nil {
+		lg.Error().Msg("StreamProducer: send message failed").String("stream", stream).Error("error", err).Fire()
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+	} else {
+		lg.Debug().Msg("StreamProducer: send message success").String("stream", stream).String("id", id).Fire()
+		span.SetTag("id", id)
+	}
+	span.Finish()
+	return
+}
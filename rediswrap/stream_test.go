@@ -0,0 +1,159 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+type streamRecorder struct {
+	mu       sync.Mutex
+	messages []*StreamMessage
+	tids     []string
+}
+
+func (r *streamRecorder) handle(ctx context.Context, messages []*StreamMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, messages...)
+	r.tids = append(r.tids, gtrace.IdFromContext(ctx))
+	return nil
+}
+
+func (r *streamRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+func startStreamConsumer(t *testing.T, c *StreamConsumer) {
+	done := make(chan struct{})
+	go func() {
+		_ = c.Consume()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = c.Close()
+		<-done
+	})
+}
+
+func TestStreamConsumer(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := newTestContext()
+
+	r := &streamRecorder{}
+	c, err := NewStreamConsumer(ctx, client, "events", "group", "c1", r.handle, WithStreamBlock(time.Millisecond*50))
+	require.Nil(t, err)
+
+	// Creates group again is ok.
+	_, err = NewStreamConsumer(ctx, client, "events", "group", "c2", r.handle)
+	require.Nil(t, err)
+
+	startStreamConsumer(t, c)
+
+	p := NewStreamProducer(ctx, client)
+	for i := 0; i < 3; i++ {
+		_, err = p.Send(gtrace.ContextWithId(ctx, "tid-1"), "events", map[string]interface{}{"n": i})
+		require.Nil(t, err)
+	}
+
+	require.Eventually(t, func() bool { return r.len() == 3 }, time.Second*5, time.Millisecond*10)
+	r.mu.Lock()
+	for i, m := range r.messages {
+		require.Equal(t, "events", m.Stream)
+		require.Equal(t, int64(1), m.Deliveries)
+		require.Equal(t, string(rune('0'+i)), m.Values["n"])
+	}
+	require.Equal(t, "tid-1", r.tids[0])
+	r.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		pending, err := client.XPending(ctx, "events", "group").Result()
+		return err == nil && pending.Count == 0
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestStreamConsumerDeadLetter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := newTestContext()
+
+	var mu sync.Mutex
+	var calls int
+	handler := func(ctx context.Context, messages []*StreamMessage) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return errors.New("handle failed")
+	}
+
+	c, err := NewStreamConsumer(ctx, client, "events", "group", "c1", handler,
+		WithStreamStartID("0"),
+		WithStreamBlock(time.Millisecond*20),
+		WithStreamRetry(0, time.Millisecond),
+		WithStreamClaim(0, time.Millisecond*10),
+		WithStreamMaxDeliveries(3),
+	)
+	require.Nil(t, err)
+
+	id, err := NewStreamProducer(ctx, client).Send(ctx, "events", map[string]interface{}{"k": "v"})
+	require.Nil(t, err)
+
+	startStreamConsumer(t, c)
+
+	var dead []redis.XMessage
+	require.Eventually(t, func() bool {
+		dead, err = client.XRange(ctx, "events:dead", "-", "+").Result()
+		return err == nil && len(dead) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	require.Equal(t, "v", dead[0].Values["k"])
+	require.Equal(t, "events", dead[0].Values[DeadLetterStreamField])
+	require.Equal(t, id, dead[0].Values[DeadLetterIdField])
+	require.Equal(t, "group", dead[0].Values[DeadLetterGroupField])
+	require.Equal(t, "4", dead[0].Values[DeadLetterDeliveriesField])
+
+	mu.Lock()
+	require.Equal(t, 3, calls)
+	mu.Unlock()
+
+	pending, err := client.XPending(ctx, "events", "group").Result()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), pending.Count)
+}
+
+func TestStreamConsumerClaim(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := newTestContext()
+
+	r := &streamRecorder{}
+	c, err := NewStreamConsumer(ctx, client, "events", "group", "alive", r.handle,
+		WithStreamBlock(time.Millisecond*20),
+		WithStreamClaim(0, time.Millisecond*10),
+	)
+	require.Nil(t, err)
+
+	id, err := NewStreamProducer(ctx, client).Send(ctx, "events", map[string]interface{}{"k": "v"})
+	require.Nil(t, err)
+
+	// A consumer reads the message and then dies.
+	_, err = client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "group", Consumer: "dead", Streams: []string{"events", ">"}, Count: 10,
+	}).Result()
+	require.Nil(t, err)
+
+	startStreamConsumer(t, c)
+
+	require.Eventually(t, func() bool { return r.len() == 1 }, time.Second*5, time.Millisecond*10)
+	r.mu.Lock()
+	require.Equal(t, id, r.messages[0].ID)
+	require.Equal(t, int64(2), r.messages[0].Deliveries)
+	r.mu.Unlock()
+}
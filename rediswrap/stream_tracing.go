@@ -0,0 +1,60 @@
+package rediswrap
+
+import (
+	"context"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+var streamComponentTag = opentracing.Tag{Key: string(ext.Component), Value: "redis-stream"}
+
+// streamCarrier carries the span context in the fields of stream message.
+type streamCarrier map[string]interface{}
+
+// Set conforms to the opentracing.TextMapWriter interface.
+func (c streamCarrier) Set(key, val string) {
+	c[key] = val
+}
+
+// ForeachKey conforms to the opentracing.TextMapReader interface.
+func (c streamCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, v := range c {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if err := handler(k, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamProducerSpan start a span for producer and injects the trace id and span context into values.
+func streamProducerSpan(ctx context.Context, tracer gtrace.Tracer, stream string, values map[string]interface{}) opentracing.Span {
+	if tid := gtrace.IdFromContext(ctx); tid != "" {
+		values[gtrace.IdKey] = tid
+	}
+
+	var parentCtx opentracing.SpanContext
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		parentCtx = parent.Context()
+	}
+
+	span := tracer.StartSpan(
+		"StreamProduceMessage",
+		opentracing.ChildOf(parentCtx),
+		ext.SpanKindProducer,
+		streamComponentTag,
+		opentracing.Tag{Key: "stream", Value: stream},
+	)
+
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, streamCarrier(values)); err != nil {
+		glog.FromContext(ctx).Error().Error("streamProducerSpan: tracer inject error", err).Fire()
+	}
+	return span
+}
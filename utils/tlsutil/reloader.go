@@ -0,0 +1,181 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The client auth modes of ParseClientAuth.
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequireAny       = "require_any"
+	ClientAuthVerifyIfGiven    = "verify_if_given"
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+// ParseClientAuth returns the tls.ClientAuthType of mode, the empty mode is ClientAuthNone.
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case ClientAuthRequireAny:
+		return tls.RequireAnyClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequireAndVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("tlsutil: unsupported client auth mode %q", mode)
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader holds the key pair and CA bundle of Options, and reloads them when the files changed.
+// The files are checked by stat each time a config is built, so the certificates renewed by tools such
+// as cert-manager are used by the new connections without restarting. The previous certificates
+// are kept if the changed files are invalid, e.g. the key pair is being written.
+type Reloader struct {
+	opts    Options
+	onError func(err error)
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps map[string]fileStamp
+}
+
+// NewReloader loads the files of opts and returns a Reloader. The onError is called with the error
+// of reloading, it can be nil.
+func NewReloader(opts *Options, onError func(err error)) (*Reloader, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("tlsutil: cert file and key file must be set together")
+	}
+	r := &Reloader{opts: *opts, onError: onError}
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(stamps); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.opts.CAFile, r.opts.CertFile, r.opts.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (r *Reloader) stat() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, fmt.Errorf("tlsutil: stat file error: %w", err)
+		}
+		stamps[f] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+func (r *Reloader) load(stamps map[string]fileStamp) error {
+	var cert *tls.Certificate
+	if r.opts.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tlsutil: load key pair error: %w", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.opts.CAFile != "" {
+		p, err := LoadCertPool(r.opts.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = p
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.stamps = cert, pool, stamps
+	r.mu.Unlock()
+	return nil
+}
+
+// reload loads the files again if any of them changed.
+func (r *Reloader) reload() {
+	stamps, err := r.stat()
+	if err == nil {
+		r.mu.RLock()
+		changed := false
+		for f, s := range stamps {
+			if old := r.stamps[f]; !old.modTime.Equal(s.modTime) || old.size != s.size {
+				changed = true
+			}
+		}
+		r.mu.RUnlock()
+		if !changed {
+			return
+		}
+		err = r.load(stamps)
+	}
+	if err != nil && r.onError != nil {
+		r.onError(err)
+	}
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.reload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns a *tls.Config for server side with the current key pair and CA bundle, the
+// peer certificates are verified by the CA bundle if clientAuth requires. Like ClientConfig, it
+// should be called for each new connection to use the reloaded files.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	cert, pool := r.current()
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+		ClientCAs:  pool,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+// ClientConfig returns a *tls.Config for client side with the current key pair and CA bundle. The
+// tls.Config cannot be changed after used, so it should be called for each new connection to use
+// the reloaded files.
+func (r *Reloader) ClientConfig() *tls.Config {
+	cert, pool := r.current()
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         r.opts.ServerName,
+		RootCAs:            pool,
+		InsecureSkipVerify: r.opts.InsecureSkipVerify,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
@@ -0,0 +1,67 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientAuth(t *testing.T) {
+	auth, err := ParseClientAuth("")
+	require.Nil(t, err)
+	require.Equal(t, tls.NoClientCert, auth)
+	auth, err = ParseClientAuth(ClientAuthRequireAndVerify)
+	require.Nil(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, auth)
+	_, err = ParseClientAuth("unknown")
+	require.NotNil(t, err)
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeTestCerts(t, dir)
+
+	var reloadErr error
+	r, err := NewReloader(&Options{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, func(err error) {
+		reloadErr = err
+	})
+	require.Nil(t, err)
+
+	cfg := r.ServerConfig(tls.RequireAndVerifyClientCert)
+	require.Len(t, cfg.Certificates, 1)
+	require.NotNil(t, cfg.ClientCAs)
+	require.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	first := cfg.Certificates[0].Certificate[0]
+
+	// Renews the certificates.
+	newDir := t.TempDir()
+	newCA, newCert, newKey := writeTestCerts(t, newDir)
+	later := time.Now().Add(time.Minute)
+	for src, dst := range map[string]string{newCA: caFile, newCert: certFile, newKey: keyFile} {
+		b, err := ioutil.ReadFile(src)
+		require.Nil(t, err)
+		require.Nil(t, ioutil.WriteFile(dst, b, 0600))
+		require.Nil(t, os.Chtimes(dst, later, later))
+	}
+	cfg = r.ClientConfig()
+	require.Len(t, cfg.Certificates, 1)
+	require.NotEqual(t, first, cfg.Certificates[0].Certificate[0])
+	require.Nil(t, reloadErr)
+	renewed := cfg.Certificates[0].Certificate[0]
+
+	// The previous certificates are kept if the files are invalid.
+	require.Nil(t, ioutil.WriteFile(keyFile, []byte("invalid"), 0600))
+	cfg = r.ClientConfig()
+	require.NotNil(t, reloadErr)
+	require.Equal(t, renewed, cfg.Certificates[0].Certificate[0])
+
+	_, err = NewReloader(&Options{CertFile: certFile}, nil)
+	require.NotNil(t, err)
+	_, err = NewReloader(&Options{CAFile: filepath.Join(dir, "not-exists.pem")}, nil)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,63 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Options describes the files and parameters used to build a *tls.Config.
+type Options struct {
+	// CAFile is the PEM encoded CA bundle used to verify the peer certificate.
+	// The system root CAs will be used if it is empty.
+	CAFile string
+	// CertFile and KeyFile is the PEM encoded key pair that presents to the peer.
+	// They are required on the server side and optional on the client side.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name used to verify the server certificate.
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server certificate.
+	// Only for testing purposes.
+	InsecureSkipVerify bool
+}
+
+// NewClientConfig creates a *tls.Config for client side by options.
+func NewClientConfig(opts *Options) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsutil: load key pair error: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LoadCertPool reads the PEM encoded certificates from file and returns a *x509.CertPool.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsutil: read ca file error: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("tlsutil: no valid certificate found in %s", caFile)
+	}
+	return pool, nil
+}
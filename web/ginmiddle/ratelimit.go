@@ -0,0 +1,122 @@
+package ginmiddle
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/rediswrap"
+	"github.com/DataWorkbench/common/utils/signer"
+)
+
+// RateLimitKeyFunc extracts the rate limit key from the request.
+// Returns an empty string to skip the rate limit for this request.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitKeyByIP uses the client ip as the rate limit key.
+func RateLimitKeyByIP() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// RateLimitKeyByRoute uses the request method and the route path as the rate limit key.
+func RateLimitKeyByRoute() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return "route:" + c.Request.Method + ":" + c.FullPath()
+	}
+}
+
+// RateLimitKeyByAccessKey uses the access key id in the "Authorization" header as the rate limit key.
+func RateLimitKeyByAccessKey() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		authorization := c.GetHeader("Authorization")
+		if authorization == "" {
+			return ""
+		}
+		accessKeyId, _, err := signer.ParseSV1Authorization(authorization)
+		if err != nil || accessKeyId == "" {
+			return ""
+		}
+		return "ak:" + accessKeyId
+	}
+}
+
+// RateLimitKeyByUser uses the value of key in gin.Context as the rate limit key.
+// The value, such as user id, is expected to be set by the authentication handler.
+func RateLimitKeyByUser(key string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		userId := c.GetString(key)
+		if userId == "" {
+			return ""
+		}
+		return "user:" + userId
+	}
+}
+
+// RateLimitKeyJoin combines multiple key funcs, e.g. limit each user on each route.
+// The rate limit is skipped if any of them returns an empty string.
+func RateLimitKeyJoin(fns ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		var key string
+		for i, fn := range fns {
+			k := fn(c)
+			if k == "" {
+				return ""
+			}
+			if i > 0 {
+				key += "|"
+			}
+			key += k
+		}
+		return key
+	}
+}
+
+// RateLimit returns a middleware that limits the requests rate by the key of keyFunc.
+//
+// The "X-RateLimit-Limit", "X-RateLimit-Remaining" and "X-RateLimit-Reset" headers are set in
+// every limited response. The request is rejected with qerror.TooManyRequests and a "Retry-After"
+// header when the limit is exceeded. The request is allowed if the limiter returns an error.
+//
+// Must be used after Trace.
+func RateLimit(limiter rediswrap.RateLimiter, keyFunc RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		ctx := GetStdContext(c)
+		result, err := limiter.Allow(ctx, key)
+		if err != nil {
+			glog.FromContext(ctx).Warn().Msg("rate limit check failed, allow the request").
+				String("key", key).Error("error", err).Fire()
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		header.Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter.Seconds()), 10))
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter.Seconds())
+			header.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			resp := qerror.NewResponse(qerror.TooManyRequests.Format(retryAfter), gtrace.IdFromContext(ctx))
+			c.AbortWithStatusJSON(resp.Status, resp)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(seconds float64) int64 {
+	return int64(math.Ceil(seconds))
+}
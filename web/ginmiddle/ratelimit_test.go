@@ -0,0 +1,57 @@
+package ginmiddle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/rediswrap"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() { _ = rdb.Close() }()
+
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	limiter := rediswrap.NewSlidingWindowLimiter(rdb, "ginmiddle-test", 2, time.Minute)
+
+	engine := gin.New()
+	engine.Use(Trace(ctx), RateLimit(limiter, RateLimitKeyByIP()))
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	do := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := do()
+		require.Equal(t, http.StatusOK, w.Code, i)
+		require.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		require.Equal(t, remaining, w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	w := do()
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+	require.NotEqual(t, "pong", w.Body.String())
+
+	// The request is allowed if the limiter fails.
+	mr.Close()
+	w = do()
+	require.Equal(t, http.StatusOK, w.Code)
+}
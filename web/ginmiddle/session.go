@@ -0,0 +1,66 @@
+package ginmiddle
+
+import (
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/web/gsession"
+)
+
+// Session returns a middleware that loads the session by the id carried by transport.
+//
+// The session is put into standard library's context.Context, get it by gsession.FromContext(ctx),
+// and the user id is added to the logger fields. The expiry of session is extended and sent back
+// to the client in every request.
+//
+// The request without a valid session is rejected with qerror.InvalidSession if required is true.
+// Otherwise, it continues without a session.
+//
+// Must be used after Trace.
+func Session(store *gsession.Store, transport gsession.Transport, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := GetStdContext(c)
+		lg := glog.FromContext(ctx)
+
+		var sess *gsession.Session
+		id := transport.Get(c.Request)
+		if id != "" {
+			var err error
+			sess, err = store.Get(ctx, id)
+			if err == gsession.ErrSessionNotFound {
+				transport.Clear(c.Writer)
+			} else if err != nil {
+				lg.Error().Error("get session error", err).Fire()
+				resp := qerror.NewResponse(qerror.Internal, gtrace.IdFromContext(ctx))
+				c.AbortWithStatusJSON(resp.Status, resp)
+				return
+			}
+		}
+
+		if sess == nil {
+			if required {
+				resp := qerror.NewResponse(qerror.InvalidSession.Format(maskSessionID(id)), gtrace.IdFromContext(ctx))
+				c.AbortWithStatusJSON(resp.Status, resp)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		lg.WithFields().AddString("uid", sess.UserID)
+		SetStdContext(c, gsession.WithContext(ctx, sess))
+		transport.Set(c.Writer, sess)
+
+		c.Next()
+	}
+}
+
+// maskSessionID returns the prefix of session id to avoid leaking it in response.
+func maskSessionID(id string) string {
+	if len(id) > 8 {
+		return id[:8] + "..."
+	}
+	return id
+}
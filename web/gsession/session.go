@@ -0,0 +1,43 @@
+package gsession
+
+import (
+	"context"
+	"time"
+)
+
+// Session is the server-side state of a logged-in user.
+type Session struct {
+	ID     string            `json:"id"`
+	UserID string            `json:"user_id"`
+	Values map[string]string `json:"values,omitempty"`
+	// CreatedAt is the unix timestamp in seconds that the session created.
+	CreatedAt int64 `json:"created_at"`
+	// ExpiresAt is the time that the session expires if not accessed. It's updated by Store.Get.
+	ExpiresAt time.Time `json:"-"`
+}
+
+// Get returns the value of key, or an empty string if not set.
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+// Set sets the value of key, Store.Save must be called to persist it.
+func (s *Session) Set(key, value string) {
+	if s.Values == nil {
+		s.Values = make(map[string]string)
+	}
+	s.Values[key] = value
+}
+
+type ctxSessionKey struct{}
+
+// WithContext returns a new context with the session.
+func WithContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, ctxSessionKey{}, s)
+}
+
+// FromContext returns the session in ctx, or nil if not found.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(ctxSessionKey{}).(*Session)
+	return s
+}
@@ -0,0 +1,258 @@
+package gsession
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/DataWorkbench/common/rediswrap"
+)
+
+const (
+	// DefaultIDLength is the default length of session id.
+	DefaultIDLength = 50
+	// DefaultTTL is the default time that a session expires if not accessed.
+	DefaultTTL = time.Hour * 24
+	// DefaultPrefix is the default prefix of redis keys.
+	DefaultPrefix = "session"
+
+	idLetters = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// ErrSessionNotFound is returned if the session not exists or expired.
+var ErrSessionNotFound = errors.New("gsession: session not found")
+
+// sessionGetScript gets the session and resets its ttl if exists.
+// KEYS[1]: session key; ARGV[1]: ttl in milliseconds.
+var sessionGetScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return v
+`)
+
+// Option used to change the behavior of Store.
+type Option func(o *options)
+
+type options struct {
+	prefix   string
+	ttl      time.Duration
+	idLength int
+}
+
+// WithPrefix sets the prefix of redis keys. Defaults "session".
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithTTL sets the time that a session expires if not accessed. Defaults 24h.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithIDLength sets the length of session id. Defaults 50.
+func WithIDLength(n int) Option {
+	return func(o *options) {
+		o.idLength = n
+	}
+}
+
+// Store persists sessions in redis with sliding expiry.
+//
+// Sessions are stored in "<prefix>:<id>", and the ids of a user are tracked in set
+// "<prefix>:user:<user_id>" for revoking all sessions of the user. The set expires with the
+// last accessed session of the user, and the ids of expired sessions are pruned on Create.
+type Store struct {
+	client rediswrap.Client
+	opts   options
+}
+
+// NewStore creates a Store.
+func NewStore(client rediswrap.Client, opts ...Option) *Store {
+	s := &Store{
+		client: client,
+		opts: options{
+			prefix:   DefaultPrefix,
+			ttl:      DefaultTTL,
+			idLength: DefaultIDLength,
+		},
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	return s
+}
+
+// TTL returns the time that a session expires if not accessed.
+func (s *Store) TTL() time.Duration {
+	return s.opts.ttl
+}
+
+func (s *Store) sessionKey(id string) string {
+	return s.opts.prefix + ":" + id
+}
+
+func (s *Store) userKey(userId string) string {
+	return s.opts.prefix + ":user:" + userId
+}
+
+// Create creates a new session for the user.
+func (s *Store) Create(ctx context.Context, userId string, values map[string]string) (*Session, error) {
+	id, err := newSessionID(s.opts.idLength)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sess := &Session{
+		ID:        id,
+		UserID:    userId,
+		Values:    values,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(s.opts.ttl),
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	// Removes the expired sessions of user to avoid the set growing.
+	if err = s.pruneUser(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.sessionKey(id), data, s.opts.ttl)
+		pipe.SAdd(ctx, s.userKey(userId), id)
+		pipe.PExpire(ctx, s.userKey(userId), s.opts.ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Get returns the session of id and extends its expiry.
+// Returns ErrSessionNotFound if the session not exists or expired.
+func (s *Store) Get(ctx context.Context, id string) (*Session, error) {
+	if len(id) != s.opts.idLength {
+		return nil, ErrSessionNotFound
+	}
+	data, err := sessionGetScript.Run(ctx, s.client, []string{s.sessionKey(id)}, s.opts.ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess := &Session{}
+	if err = json.Unmarshal([]byte(data), sess); err != nil {
+		return nil, err
+	}
+	// The set of user lives as long as the session.
+	if err = s.client.PExpire(ctx, s.userKey(sess.UserID), s.opts.ttl).Err(); err != nil {
+		return nil, err
+	}
+	sess.ExpiresAt = time.Now().Add(s.opts.ttl)
+	return sess, nil
+}
+
+// Save persists the values of session and extends its expiry.
+// Returns ErrSessionNotFound if the session has been deleted or expired.
+func (s *Store) Save(ctx context.Context, sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	ok, err := s.client.SetXX(ctx, s.sessionKey(sess.ID), data, s.opts.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if err = s.client.PExpire(ctx, s.userKey(sess.UserID), s.opts.ttl).Err(); err != nil {
+		return err
+	}
+	sess.ExpiresAt = time.Now().Add(s.opts.ttl)
+	return nil
+}
+
+// Delete deletes the session, e.g. when user logout.
+func (s *Store) Delete(ctx context.Context, sess *Session) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.sessionKey(sess.ID))
+		pipe.SRem(ctx, s.userKey(sess.UserID), sess.ID)
+		return nil
+	})
+	return err
+}
+
+// RevokeUser deletes all sessions of the user, e.g. when the password changed or user banned.
+func (s *Store) RevokeUser(ctx context.Context, userId string) error {
+	ids, err := s.client.SMembers(ctx, s.userKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	// Deletes the keys one by one because they may be in different slots in cluster mode.
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.Del(ctx, s.sessionKey(id))
+		}
+		pipe.Del(ctx, s.userKey(userId))
+		return nil
+	})
+	return err
+}
+
+// pruneUser removes the ids of expired sessions from the set of user.
+func (s *Store) pruneUser(ctx context.Context, userId string) error {
+	ids, err := s.client.SMembers(ctx, s.userKey(userId)).Result()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	cmds := make([]*redis.IntCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Exists(ctx, s.sessionKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var expired []interface{}
+	for i, cmd := range cmds {
+		if cmd.Val() == 0 {
+			expired = append(expired, ids[i])
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return s.client.SRem(ctx, s.userKey(userId), expired...).Err()
+}
+
+// newSessionID generates a random id by crypto/rand.
+func newSessionID(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(idLetters)))
+	for i := range b {
+		x, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = idLetters[x.Int64()]
+	}
+	return string(b), nil
+}
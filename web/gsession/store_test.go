@@ -0,0 +1,118 @@
+package gsession
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, opts ...Option) (*miniredis.Miniredis, *Store) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, NewStore(rdb, opts...)
+}
+
+func TestStore(t *testing.T) {
+	mr, store := newTestStore(t, WithTTL(time.Hour))
+	ctx := context.Background()
+
+	sess, err := store.Create(ctx, "usr-1", map[string]string{"role": "admin"})
+	require.Nil(t, err)
+	require.Len(t, sess.ID, DefaultIDLength)
+	require.Equal(t, time.Hour, mr.TTL("session:"+sess.ID))
+	require.Equal(t, time.Hour, mr.TTL("session:user:usr-1"))
+
+	// Sliding expiry.
+	mr.FastForward(time.Minute * 30)
+	got, err := store.Get(ctx, sess.ID)
+	require.Nil(t, err)
+	require.Equal(t, "usr-1", got.UserID)
+	require.Equal(t, "admin", got.Get("role"))
+	require.Equal(t, time.Hour, mr.TTL("session:"+sess.ID))
+	require.Equal(t, time.Hour, mr.TTL("session:user:usr-1"))
+
+	got.Set("theme", "dark")
+	require.Nil(t, store.Save(ctx, got))
+	got, err = store.Get(ctx, sess.ID)
+	require.Nil(t, err)
+	require.Equal(t, "dark", got.Get("theme"))
+
+	// Expired.
+	mr.FastForward(time.Hour)
+	_, err = store.Get(ctx, sess.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+	require.Equal(t, ErrSessionNotFound, store.Save(ctx, got))
+	// The set of user expires with the session.
+	require.False(t, mr.Exists("session:user:usr-1"))
+
+	_, err = store.Get(ctx, "invalid")
+	require.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestStoreRevoke(t *testing.T) {
+	mr, store := newTestStore(t)
+	ctx := context.Background()
+
+	s1, err := store.Create(ctx, "usr-1", nil)
+	require.Nil(t, err)
+	s2, err := store.Create(ctx, "usr-1", nil)
+	require.Nil(t, err)
+	s3, err := store.Create(ctx, "usr-2", nil)
+	require.Nil(t, err)
+	require.NotEqual(t, s1.ID, s2.ID)
+
+	require.Nil(t, store.Delete(ctx, s1))
+	_, err = store.Get(ctx, s1.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+	members, err := mr.Members("session:user:usr-1")
+	require.Nil(t, err)
+	require.Equal(t, []string{s2.ID}, members)
+
+	require.Nil(t, store.RevokeUser(ctx, "usr-1"))
+	_, err = store.Get(ctx, s2.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+	require.False(t, mr.Exists("session:user:usr-1"))
+
+	_, err = store.Get(ctx, s3.ID)
+	require.Nil(t, err)
+}
+
+func TestStorePruneUser(t *testing.T) {
+	mr, store := newTestStore(t, WithTTL(time.Minute))
+	ctx := context.Background()
+
+	_, err := store.Create(ctx, "usr-1", nil)
+	require.Nil(t, err)
+	mr.FastForward(time.Minute * 2)
+
+	s2, err := store.Create(ctx, "usr-1", nil)
+	require.Nil(t, err)
+	members, err := mr.Members("session:user:usr-1")
+	require.Nil(t, err)
+	require.Equal(t, []string{s2.ID}, members)
+}
+
+func TestTransport(t *testing.T) {
+	sess := &Session{ID: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+
+	cookie := NewCookieTransport("sid")
+	w := httptest.NewRecorder()
+	cookie.Set(w, sess)
+	r := &http.Request{Header: http.Header{"Cookie": w.Header()["Set-Cookie"]}}
+	require.Equal(t, "abc", cookie.Get(r))
+	require.Contains(t, w.Header().Get("Set-Cookie"), "HttpOnly")
+
+	header := NewHeaderTransport("X-Session-Id")
+	w = httptest.NewRecorder()
+	header.Set(w, sess)
+	require.Equal(t, "abc", w.Header().Get("X-Session-Id"))
+	r = &http.Request{Header: http.Header{"X-Session-Id": []string{"abc"}}}
+	require.Equal(t, "abc", header.Get(r))
+}
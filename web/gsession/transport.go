@@ -0,0 +1,98 @@
+package gsession
+
+import (
+	"net/http"
+	"time"
+)
+
+var (
+	_ Transport = (*CookieTransport)(nil)
+	_ Transport = (*HeaderTransport)(nil)
+)
+
+// Transport carries the session id between client and server.
+type Transport interface {
+	// Get returns the session id in request, or an empty string if not found.
+	Get(r *http.Request) string
+	// Set writes the session id to response.
+	Set(w http.ResponseWriter, s *Session)
+	// Clear tells the client to drop the session id.
+	Clear(w http.ResponseWriter)
+}
+
+// CookieTransport carries the session id in cookie, used by browsers.
+type CookieTransport struct {
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// NewCookieTransport creates a CookieTransport with secure defaults.
+func NewCookieTransport(name string) *CookieTransport {
+	return &CookieTransport{
+		Name:     name,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Get implements Transport.
+func (t *CookieTransport) Get(r *http.Request) string {
+	c, err := r.Cookie(t.Name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// Set implements Transport. The cookie expires with the session.
+func (t *CookieTransport) Set(w http.ResponseWriter, s *Session) {
+	http.SetCookie(w, t.cookie(s.ID, s.ExpiresAt, int(time.Until(s.ExpiresAt).Seconds())))
+}
+
+// Clear implements Transport.
+func (t *CookieTransport) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, t.cookie("", time.Unix(0, 0), -1))
+}
+
+func (t *CookieTransport) cookie(value string, expires time.Time, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     t.Name,
+		Value:    value,
+		Path:     t.Path,
+		Domain:   t.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   t.Secure,
+		HttpOnly: true,
+		SameSite: t.SameSite,
+	}
+}
+
+// HeaderTransport carries the session id in http header, used by non-browser clients.
+type HeaderTransport struct {
+	Name string
+}
+
+// NewHeaderTransport creates a HeaderTransport.
+func NewHeaderTransport(name string) *HeaderTransport {
+	return &HeaderTransport{Name: name}
+}
+
+// Get implements Transport.
+func (t *HeaderTransport) Get(r *http.Request) string {
+	return r.Header.Get(t.Name)
+}
+
+// Set implements Transport.
+func (t *HeaderTransport) Set(w http.ResponseWriter, s *Session) {
+	w.Header().Set(t.Name, s.ID)
+}
+
+// Clear implements Transport.
+func (t *HeaderTransport) Clear(w http.ResponseWriter) {
+	w.Header().Del(t.Name)
+}